@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/AbduAllahGabbar/service/pkg/cache"
+	"github.com/AbduAllahGabbar/service/pkg/rolesync"
+	"github.com/AbduAllahGabbar/service/pkg/service"
+	"github.com/AbduAllahGabbar/service/pkg/zitadel"
+)
+
+var errUsage = errors.New("invalid usage, run rolectl -h")
+
+func (a *app) run(ctx context.Context, args []string) error {
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "roles":
+		return a.roles(ctx, args)
+	case "assign":
+		return a.assign(ctx, args)
+	case "revoke":
+		return a.revoke(ctx, args)
+	case "user":
+		return a.user(ctx, args)
+	case "cache":
+		return a.cache(ctx, args)
+	case "jobs":
+		return a.jobs(ctx, args)
+	case "sync":
+		return a.sync(ctx, args)
+	}
+	return fmt.Errorf("unknown command %q, run rolectl -h", cmd)
+}
+
+func (a *app) roles(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	sub, args := args[0], args[1:]
+	switch sub {
+	case "list":
+		var q zitadel.RoleQuery
+		fs := flag.NewFlagSet("roles list", flag.ContinueOnError)
+		fs.StringVar(&q.Key, "key", "", "only keys containing this text")
+		fs.StringVar(&q.DisplayName, "display-name", "", "only display names containing this text")
+		fs.StringVar(&q.SortBy, "sort", zitadel.RoleSortKey, "sort by key or display_name")
+		fs.BoolVar(&q.Desc, "desc", false, "sort descending")
+		fs.IntVar(&q.Offset, "offset", 0, "skip this many roles")
+		fs.IntVar(&q.Limit, "limit", 0, "show at most this many roles")
+		if pos, err := parseArgs(fs, args); err != nil || len(pos) != 0 {
+			return errUsage
+		}
+		list, err := a.svc.ListRoles(ctx, q)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(list.Roles))
+		for _, r := range list.Roles {
+			rows = append(rows, []string{r.Key, r.DisplayName, r.Group})
+		}
+		return a.out.table(list, []string{"KEY", "DISPLAY NAME", "GROUP"}, rows)
+
+	case "create":
+		fs := flag.NewFlagSet("roles create", flag.ContinueOnError)
+		display := fs.String("display-name", "", "display name (defaults to the key)")
+		group := fs.String("group", "", "role group")
+		pos, err := parseArgs(fs, args)
+		if err != nil || len(pos) != 1 {
+			return errUsage
+		}
+		if *display == "" {
+			*display = pos[0]
+		}
+		if _, err := a.svc.CreateRoles(ctx, []zitadel.RoleInput{{Name: pos[0], Desc: *display, Group: *group}}); err != nil {
+			return err
+		}
+		return a.out.message(map[string]interface{}{"ok": true, "role": pos[0]}, "created role %s", pos[0])
+
+	case "update":
+		var u service.RoleUpdate
+		fs := flag.NewFlagSet("roles update", flag.ContinueOnError)
+		fs.Func("display-name", "new display name", func(v string) error { u.DisplayName = &v; return nil })
+		fs.Func("group", "new group", func(v string) error { u.Group = &v; return nil })
+		pos, err := parseArgs(fs, args)
+		if err != nil || len(pos) != 1 || (u.DisplayName == nil && u.Group == nil) {
+			return errUsage
+		}
+		role, err := a.svc.UpdateRole(ctx, pos[0], u)
+		if err != nil {
+			return err
+		}
+		return a.out.table(role, []string{"KEY", "DISPLAY NAME", "GROUP"}, [][]string{{role.Key, role.DisplayName, role.Group}})
+
+	case "users":
+		fs := flag.NewFlagSet("roles users", flag.ContinueOnError)
+		offset := fs.Int("offset", 0, "skip this many grants")
+		limit := fs.Int("limit", 0, "show at most this many grants")
+		pos, err := parseArgs(fs, args)
+		if err != nil || len(pos) != 1 {
+			return errUsage
+		}
+		list, err := a.svc.ListUsersWithRole(ctx, pos[0], *offset, *limit)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(list.Grants))
+		for _, g := range list.Grants {
+			rows = append(rows, []string{g.UserID, g.GrantID, g.CreatedAt.Format(time.RFC3339)})
+		}
+		return a.out.table(list, []string{"USER", "GRANT", "CREATED"}, rows)
+
+	case "delete":
+		fs := flag.NewFlagSet("roles delete", flag.ContinueOnError)
+		noWait := fs.Bool("no-wait", false, "do not wait for the cache cleanup job")
+		pos, err := parseArgs(fs, args)
+		if err != nil || len(pos) != 1 {
+			return errUsage
+		}
+		jobID, err := a.svc.DeleteRoleWithJob(ctx, pos[0])
+		if err != nil {
+			return err
+		}
+		if *noWait {
+			return a.out.message(map[string]interface{}{"ok": true, "job_id": jobID}, "deleted role %s, cleanup job %s", pos[0], jobID)
+		}
+		// the cleanup job runs in this process, so it has to finish before
+		// rolectl exits
+		return a.watchJob(ctx, jobID, 500*time.Millisecond)
+	}
+	return errUsage
+}
+
+func (a *app) assign(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("assign", flag.ContinueOnError)
+	dur := fs.Duration("for", 0, "temporary grant for this long")
+	expires := fs.String("expires-at", "", "temporary grant until this RFC3339 time")
+	pos, err := parseArgs(fs, args)
+	if err != nil || len(pos) != 2 || (*dur != 0 && *expires != "") {
+		return errUsage
+	}
+	var opts []service.AssignOption
+	var until time.Time
+	switch {
+	case *dur > 0:
+		until = time.Now().Add(*dur)
+	case *expires != "":
+		if until, err = time.Parse(time.RFC3339, *expires); err != nil {
+			return fmt.Errorf("-expires-at: %w", err)
+		}
+	}
+	if !until.IsZero() {
+		opts = append(opts, service.WithExpiry(until))
+	}
+	if err := a.svc.AssignRole(ctx, pos[0], pos[1], opts...); err != nil {
+		return err
+	}
+	if until.IsZero() {
+		return a.out.message(map[string]interface{}{"ok": true}, "assigned %s to %s", pos[0], pos[1])
+	}
+	return a.out.message(map[string]interface{}{"ok": true, "expires_at": until.UTC()},
+		"assigned %s to %s until %s", pos[0], pos[1], until.UTC().Format(time.RFC3339))
+}
+
+func (a *app) revoke(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	if err := a.svc.RemoveRoleFromUser(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+	return a.out.message(map[string]interface{}{"ok": true}, "revoked %s from %s", args[0], args[1])
+}
+
+func (a *app) user(ctx context.Context, args []string) error {
+	if len(args) != 2 || args[0] != "roles" {
+		return errUsage
+	}
+	userID := args[1]
+	cached, ok, err := a.svc.GetCachedUserRoles(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("read cache: %w", err)
+	}
+	live, err := a.svc.GetLiveUserRoles(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("fetch live roles: %w", err)
+	}
+	effective, err := a.svc.ExpandRoles(ctx, live)
+	if err != nil {
+		return err
+	}
+
+	v := map[string]interface{}{"user": userID, "cached": cached, "cache_hit": ok, "live": live, "effective": effective}
+	cachedCol := strings.Join(cached, ",")
+	if !ok {
+		cachedCol = "(not cached)"
+	}
+	rows := [][]string{
+		{"cached", cachedCol},
+		{"live", strings.Join(live, ",")},
+		{"effective", strings.Join(effective, ",")},
+	}
+	if ok && !sameSet(cached, live) {
+		rows = append(rows, []string{"", "cache is stale, run: rolectl cache invalidate " + userID})
+	}
+	return a.out.table(v, []string{"SOURCE", "ROLES"}, rows)
+}
+
+func (a *app) cache(ctx context.Context, args []string) error {
+	if len(args) < 2 || args[0] != "invalidate" {
+		return errUsage
+	}
+	for _, userID := range args[1:] {
+		if err := a.svc.InvalidateRoles(ctx, userID); err != nil {
+			return fmt.Errorf("invalidate %s: %w", userID, err)
+		}
+	}
+	return a.out.message(map[string]interface{}{"ok": true, "users": args[1:]}, "invalidated %d user(s)", len(args)-1)
+}
+
+func (a *app) jobs(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	sub, args := args[0], args[1:]
+	switch sub {
+	case "get":
+		if len(args) != 1 {
+			return errUsage
+		}
+		if st, err := a.svc.GetCleanupJobStatus(ctx, args[0]); err == nil {
+			return a.out.table(st, jobHeader, [][]string{jobRow(st)})
+		}
+		job, err := a.svc.GetBulkAssignJob(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(job.Results))
+		for _, r := range job.Results {
+			rows = append(rows, []string{r.UserID, r.Status, r.Code, r.Error})
+		}
+		if err := a.out.table(job, []string{"USER", "STATUS", "CODE", "ERROR"}, rows); err != nil {
+			return err
+		}
+		if a.out.json {
+			return nil
+		}
+		_, line, _ := bulkJobLine(job)
+		_, err = fmt.Fprintln(a.out.w, line)
+		return err
+	case "watch":
+		fs := flag.NewFlagSet("jobs watch", flag.ContinueOnError)
+		interval := fs.Duration("interval", time.Second, "poll interval")
+		pos, err := parseArgs(fs, args)
+		if err != nil || len(pos) != 1 {
+			return errUsage
+		}
+		return a.watchJob(ctx, pos[0], *interval)
+	}
+	return errUsage
+}
+
+var jobHeader = []string{"JOB", "ROLE", "STATUS", "PROCESSED", "UPDATED", "STARTED", "FINISHED", "ERROR"}
+
+func jobRow(st *cache.CleanupJobStatus) []string {
+	finished := ""
+	if !st.FinishedAt.IsZero() {
+		finished = st.FinishedAt.Format(time.RFC3339)
+	}
+	return []string{st.JobID, st.Role, st.Status, fmt.Sprint(st.Processed), fmt.Sprint(st.Updated),
+		st.StartedAt.Format(time.RFC3339), finished, st.Error}
+}
+
+func bulkJobLine(job *cache.BulkAssignJob) (string, string, string) {
+	return job.Status, fmt.Sprintf("%s %s %s processed=%d/%d succeeded=%d failed=%d",
+		job.JobID, job.Role, job.Status, job.Processed, job.Total, job.Succeeded, job.Failed), job.Error
+}
+
+// watchJob polls a cleanup or bulk assignment job until it is done or
+// failed, printing a line per progress change (one JSON object per line
+// with -o json).
+func (a *app) watchJob(ctx context.Context, jobID string, interval time.Duration) error {
+	var last string
+	for {
+		var (
+			v                    interface{}
+			status, line, jobErr string
+		)
+		if st, err := a.svc.GetCleanupJobStatus(ctx, jobID); err == nil {
+			v, status, jobErr = st, st.Status, st.Error
+			line = fmt.Sprintf("%s %s processed=%d updated=%d", st.JobID, st.Status, st.Processed, st.Updated)
+		} else {
+			job, err := a.svc.GetBulkAssignJob(ctx, jobID)
+			if err != nil {
+				return err
+			}
+			v = job
+			status, line, jobErr = bulkJobLine(job)
+		}
+		if line != last {
+			if err := a.out.message(v, "%s", line); err != nil {
+				return err
+			}
+			last = line
+		}
+		switch status {
+		case "done":
+			return nil
+		case "failed":
+			return fmt.Errorf("job %s failed: %s", jobID, jobErr)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (a *app) sync(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	file := fs.String("f", "", "manifest file (YAML or JSON)")
+	dryRun := fs.Bool("dry-run", false, "only print the plan")
+	prune := fs.Bool("prune", false, "delete project roles missing from the manifest")
+	fs.SetOutput(io.Discard)
+	if pos, err := parseArgs(fs, args); err != nil || len(pos) != 0 || *file == "" {
+		return errUsage
+	}
+	m, err := rolesync.LoadManifest(*file)
+	if err != nil {
+		return err
+	}
+	plan, err := a.svc.PlanRoleSync(ctx, m, rolesync.Options{Prune: *prune})
+	if err != nil {
+		return err
+	}
+	if *dryRun || plan.Empty() {
+		return a.out.message(plan, "%s", strings.TrimRight(plan.String(), "\n"))
+	}
+	res, err := a.svc.ApplyRoleSync(ctx, plan)
+	if perr := a.out.message(map[string]interface{}{"plan": plan, "result": res}, "%s%s",
+		plan.String(), summarize(res)); perr != nil {
+		return perr
+	}
+	return err
+}
+
+func summarize(res *rolesync.Result) string {
+	if res == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, s := range res.Steps {
+		if s.Error != "" {
+			fmt.Fprintf(&b, "! %s %s %s: %s\n", s.Op, s.Role, s.UserID, s.Error)
+		}
+	}
+	fmt.Fprintf(&b, "applied %d step(s), %d failed", len(res.Steps)-res.Failed, res.Failed)
+	return b.String()
+}
+
+// parseArgs parses flags that may appear between positional arguments and
+// returns the positionals.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return pos, nil
+		}
+		pos = append(pos, args[0])
+		args = args[1:]
+	}
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	m := make(map[string]int, len(a))
+	for _, s := range a {
+		m[s]++
+	}
+	for _, s := range b {
+		if m[s] == 0 {
+			return false
+		}
+		m[s]--
+	}
+	return true
+}
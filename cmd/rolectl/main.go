@@ -0,0 +1,149 @@
+// Command rolectl manages roles, assignments and caches directly through
+// pkg/service, using the same environment configuration as cmd/server.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/AbduAllahGabbar/service/pkg/audit"
+	"github.com/AbduAllahGabbar/service/pkg/cache"
+	"github.com/AbduAllahGabbar/service/pkg/config"
+	"github.com/AbduAllahGabbar/service/pkg/scope"
+	"github.com/AbduAllahGabbar/service/pkg/service"
+	"github.com/AbduAllahGabbar/service/pkg/zitadel"
+)
+
+const usage = `usage: rolectl [-o table|json] [-actor name] [-org id] [-project id] <command> [args]
+
+commands:
+  roles list [-key text] [-display-name text] [-sort key|display_name] [-desc]
+             [-offset n] [-limit n]
+  roles create <key> [-display-name name] [-group name]
+  roles update <key> [-display-name name] [-group name]
+  roles users <key> [-offset n] [-limit n]
+  roles delete <key> [-no-wait]
+  assign <role> <user> [-for 8h | -expires-at RFC3339]
+  revoke <role> <user>
+  user roles <user>             cached, live and effective roles
+  cache invalidate <user>...
+  jobs get <id>
+  jobs watch <id> [-interval 1s]
+  sync -f <manifest> [-dry-run] [-prune]
+`
+
+type app struct {
+	svc *service.Service
+	out *printer
+}
+
+func main() {
+	_ = godotenv.Load()
+
+	global := flag.NewFlagSet("rolectl", flag.ExitOnError)
+	global.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	format := global.String("o", "table", "output format: table or json")
+	actor := global.String("actor", defaultActor(), "actor recorded in the audit log")
+	org := global.String("org", "", "Zitadel organization to act in (default ZITADEL_ORG_ID)")
+	project := global.String("project", "", "Zitadel project to manage (default PROJECT_ID)")
+	_ = global.Parse(os.Args[1:])
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+	if *format != "table" && *format != "json" {
+		fatalf("unknown output format %q", *format)
+	}
+
+	svc, closeFn, err := newService()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	defer closeFn()
+
+	a := &app{svc: svc, out: &printer{json: *format == "json", w: os.Stdout}}
+	ctx := audit.WithActor(context.Background(), *actor)
+	if *org != "" {
+		ctx = scope.WithOrgID(ctx, *org)
+	}
+	if *project != "" {
+		ctx = scope.WithProjectID(ctx, *project)
+	}
+	if err := a.run(ctx, global.Args()); err != nil {
+		closeFn()
+		fatalf("%v", err)
+	}
+}
+
+func newService() (*service.Service, func(), error) {
+	cfg := config.LoadConfig()
+	tokens, err := zitadel.NewTokenSource(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("zitadel auth: %w", err)
+	}
+	zitadelClient := zitadel.NewHTTPClientWithTokenSource(cfg.ZitadelBaseURL, tokens, cfg)
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		_ = rdb.Close()
+		return nil, nil, fmt.Errorf("redis ping failed: %w", err)
+	}
+	closers := []func(){func() { _ = rdb.Close() }}
+
+	opts := []service.Option{service.WithBulkConcurrency(cfg.BulkAssignConcurrency)}
+	if cfg.RoleInheritance {
+		opts = append(opts, service.WithEffectiveRoles())
+	}
+	switch cfg.AuditSink {
+	case "redis":
+		opts = append(opts, service.WithAudit(audit.NewRedisSink(rdb, "audit:events", cfg.AuditStreamMaxLen)))
+	case "file":
+		sink, err := audit.NewFileSink(cfg.AuditFile)
+		if err != nil {
+			_ = rdb.Close()
+			return nil, nil, fmt.Errorf("open AUDIT_FILE: %w", err)
+		}
+		closers = append(closers, func() { _ = sink.Close() })
+		opts = append(opts, service.WithAudit(sink))
+	}
+
+	svc := service.New(zitadelClient, cache.NewRedisCache(rdb, cfg.CacheTTL, cache.WithDefaultProject(cfg.ProjectID)), cfg.CacheTTL, opts...)
+	closed := false
+	return svc, func() {
+		if closed {
+			return
+		}
+		closed = true
+		for _, c := range closers {
+			c()
+		}
+	}, nil
+}
+
+func defaultActor() string {
+	if v := os.Getenv("ROLECTL_ACTOR"); v != "" {
+		return v
+	}
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "rolectl: "+format+"\n", args...)
+	os.Exit(1)
+}
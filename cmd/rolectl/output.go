@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// printer writes either human-readable tables and messages or the
+// underlying value as JSON.
+type printer struct {
+	json bool
+	w    io.Writer
+}
+
+func (p *printer) table(v interface{}, header []string, rows [][]string) error {
+	if p.json {
+		return p.encode(v)
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (p *printer) message(v interface{}, format string, args ...interface{}) error {
+	if p.json {
+		return p.encode(v)
+	}
+	_, err := fmt.Fprintf(p.w, format+"\n", args...)
+	return err
+}
+
+func (p *printer) encode(v interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -2,10 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,13 +21,18 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
 
+	"github.com/AbduAllahGabbar/service/pkg/audit"
 	"github.com/AbduAllahGabbar/service/pkg/cache"
 	"github.com/AbduAllahGabbar/service/pkg/config"
 	"github.com/AbduAllahGabbar/service/pkg/middleware"
+	"github.com/AbduAllahGabbar/service/pkg/rolesync"
 	"github.com/AbduAllahGabbar/service/pkg/service"
 	"github.com/AbduAllahGabbar/service/pkg/zitadel"
 )
 
+// maxBulkAssignUsers caps the users of one POST /v1/roles/:role/users.
+const maxBulkAssignUsers = 10000
+
 func main() {
 	_ = godotenv.Load()
 	cfg := config.LoadConfig()
@@ -39,15 +52,82 @@ func main() {
 	// ensure redis closed on exit
 	defer func() { _ = rdb.Close() }()
 
-	cacheImpl := cache.NewRedisCache(rdb, cfg.CacheTTL)
-	zitadelClient := zitadel.NewHTTPClient(cfg.ZitadelBaseURL, cfg.ZitadelToken, cfg)
-	svc := service.New(zitadelClient, cacheImpl, cfg.CacheTTL)
+	cacheImpl := cache.NewRedisCache(rdb, cfg.CacheTTL, cache.WithDefaultProject(cfg.ProjectID))
+	tokens, err := zitadel.NewTokenSource(cfg)
+	if err != nil {
+		log.Fatalf("zitadel auth: %v", err)
+	}
+	zitadelClient := zitadel.NewHTTPClientWithTokenSource(cfg.ZitadelBaseURL, tokens, cfg)
+	var svcOpts []service.Option
+	if cfg.RoleInheritance {
+		svcOpts = append(svcOpts, service.WithEffectiveRoles())
+	}
+	svcOpts = append(svcOpts, service.WithAccessApprovers(cfg.AccessApprovers))
+	svcOpts = append(svcOpts, service.WithBulkConcurrency(cfg.BulkAssignConcurrency))
+	switch cfg.AuditSink {
+	case "redis":
+		svcOpts = append(svcOpts, service.WithAudit(audit.NewRedisSink(rdb, "audit:events", cfg.AuditStreamMaxLen)))
+	case "file":
+		sink, err := audit.NewFileSink(cfg.AuditFile)
+		if err != nil {
+			log.Fatalf("open AUDIT_FILE: %v", err)
+		}
+		defer func() { _ = sink.Close() }()
+		svcOpts = append(svcOpts, service.WithAudit(sink))
+	case "none":
+	default:
+		log.Fatalf("unknown AUDIT_SINK %q (redis, file or none)", cfg.AuditSink)
+	}
+	svc := service.New(zitadelClient, cacheImpl, cfg.CacheTTL, svcOpts...)
+	go svc.RunExpiryReaper(context.Background(), cfg.ExpiryReapInterval)
 
 	r := gin.New()
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
+	r.Use(middleware.RequestID())
+
+	roleMW := middleware.RoleMiddleware(svc)
+	// only management calls may switch organization; it is always mounted
+	// right after roleMW as the allowed organizations depend on the caller's
+	// roles
+	orgMW := middleware.OrgContext(cfg.ZitadelOrgAccess, cfg.ZitadelOrgID)
+	// likewise for the projects reached through /v1/projects/{project}/...
+	projectMW := middleware.ProjectAccess(cfg.ProjectAccess, cfg.ProjectID)
+
+	// Zitadel calls the webhook without a user token, so it stays outside the
+	// policy-protected group
+	r.POST("/v1/webhook/zitadel", func(c *gin.Context) {
+		var evt struct {
+			UserID string `json:"user_id"`
+			Type   string `json:"type"`
+			Role   string `json:"role,omitempty"`
+		}
+		if err := c.ShouldBindJSON(&evt); err != nil {
+			c.JSON(400, gin.H{"error": "invalid"})
+			return
+		}
+		if evt.UserID != "" {
+			_ = svc.InvalidateRoles(c.Request.Context(), evt.UserID)
+		}
+		if evt.Type == "role.deleted" && evt.Role != "" {
+			_, _ = svc.StartRemoveRoleCleanup(c.Request.Context(), evt.Role)
+		}
+		c.Status(200)
+	})
 
-	api := r.Group("/v1")
+	// every management route needs an authenticated caller; POLICY_FILE
+	// only adds per-route role rules on top
+	api := r.Group("/v1", roleMW, orgMW, projectMW)
+
+	if cfg.PolicyFile != "" {
+		engine, err := middleware.NewPolicyEngine(cfg.PolicyFile)
+		if err != nil {
+			log.Fatalf("load policy: %v", err)
+		}
+		go engine.Watch(context.Background(), cfg.PolicyReloadInterval)
+		api.Use(engine.Middleware())
+		api.POST("/policy/explain", engine.ExplainHandler(svc))
+	}
 
 	// handlers (kept same as before) -------------------------------------------------
 	api.POST("/roles/batch", func(c *gin.Context) {
@@ -56,30 +136,43 @@ func main() {
 			c.JSON(400, gin.H{"error": "invalid"})
 			return
 		}
-		_, err := svc.CreateRoles(c.Request.Context(), req)
+		// idempotent=true treats existing roles as success,
+		// update_display_name=true also renames them, atomic=true undoes the
+		// batch if any role failed
+		res, err := svc.CreateRolesBatch(c.Request.Context(), req, service.RoleBatchOptions{
+			Idempotent:        c.Query("idempotent") == "true",
+			UpdateDisplayName: c.Query("update_display_name") == "true",
+			Atomic:            c.Query("atomic") == "true",
+		})
 		if err != nil {
-			log.Printf("CreateRoles failed: %v", err)
+			log.Printf("CreateRolesBatch failed: %v", err)
 			c.JSON(500, gin.H{"error": "create_failed"})
 			return
 		}
-		c.JSON(201, gin.H{"ok": true})
+		c.JSON(batchStatus(res), res)
 	})
 
 	api.POST("/roles/assign/batch", func(c *gin.Context) {
 		var req struct {
-			UserID  string   `json:"user_id" binding:"required"`
-			RoleIDs []string `json:"role_ids" binding:"required"`
+			UserID    string     `json:"user_id" binding:"required"`
+			RoleIDs   []string   `json:"role_ids" binding:"required"`
+			ExpiresAt *time.Time `json:"expires_at"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(400, gin.H{"error": "invalid"})
 			return
 		}
-		if err := svc.AssignRolesToUser(c.Request.Context(), req.UserID, req.RoleIDs); err != nil {
-			log.Printf("AssignRolesToUser failed: %v", err)
+		opts, ok := assignOptions(c, req.ExpiresAt)
+		if !ok {
+			return
+		}
+		res, err := svc.AssignRolesBatch(c.Request.Context(), req.UserID, req.RoleIDs, c.Query("atomic") == "true", opts...)
+		if err != nil {
+			log.Printf("AssignRolesBatch failed: %v", err)
 			c.JSON(500, gin.H{"error": "assign_failed"})
 			return
 		}
-		c.JSON(200, gin.H{"ok": true})
+		c.JSON(batchStatus(res), res)
 	})
 
 	api.DELETE("/roles/:role", func(c *gin.Context) {
@@ -88,12 +181,13 @@ func main() {
 			c.JSON(400, gin.H{"error": "missing role"})
 			return
 		}
-		if err := svc.DeleteRole(c.Request.Context(), role); err != nil {
+		jobID, err := svc.DeleteRoleWithJob(c.Request.Context(), role)
+		if err != nil {
 			log.Printf("DeleteRole failed: %v", err)
 			c.JSON(500, gin.H{"error": "delete_failed"})
 			return
 		}
-		c.JSON(200, gin.H{"ok": true})
+		c.JSON(200, gin.H{"ok": true, "job_id": jobID})
 	})
 
 	api.DELETE("/roles/:role/users/:user", func(c *gin.Context) {
@@ -111,34 +205,300 @@ func main() {
 		c.JSON(200, gin.H{"ok": true})
 	})
 
-	api.POST("/roles", func(c *gin.Context) {
+	api.GET("/roles/:role/users", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		if limit <= 0 || limit > 500 {
+			limit = 50
+		}
+		offset := 0
+		if cur := c.Query("cursor"); cur != "" {
+			off, ok := decodeOffsetCursor(cur)
+			if !ok {
+				c.JSON(400, gin.H{"error": "invalid", "detail": "invalid cursor"})
+				return
+			}
+			offset = off
+		}
+		list, err := svc.ListUsersWithRole(c.Request.Context(), c.Param("role"), offset, limit)
+		if err != nil {
+			log.Printf("ListUsersWithRole failed: %v", err)
+			c.JSON(500, gin.H{"error": "fetch_failed"})
+			return
+		}
+		resp := gin.H{"role": c.Param("role"), "users": list.Grants, "total": list.Total}
+		if next := offset + len(list.Grants); len(list.Grants) > 0 && next < list.Total {
+			resp["next_cursor"] = encodeOffsetCursor(next)
+		}
+		c.JSON(200, resp)
+	})
+
+	// bulk assignment of one role; large inputs (or async=true) run as a job
+	// served by GET /v1/jobs/:id
+	api.POST("/roles/:role/users", func(c *gin.Context) {
+		var req struct {
+			UserIDs   []string   `json:"user_ids" binding:"required"`
+			ExpiresAt *time.Time `json:"expires_at"`
+			Async     bool       `json:"async"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.UserIDs) == 0 {
+			c.JSON(400, gin.H{"error": "invalid"})
+			return
+		}
+		if len(req.UserIDs) > maxBulkAssignUsers {
+			c.JSON(400, gin.H{"error": "invalid", "detail": fmt.Sprintf("at most %d user_ids", maxBulkAssignUsers)})
+			return
+		}
+		opts, ok := assignOptions(c, req.ExpiresAt)
+		if !ok {
+			return
+		}
+		role := c.Param("role")
+		if req.Async || len(req.UserIDs) > cfg.BulkAssignSyncLimit {
+			jobID, err := svc.StartAssignRoleToUsers(c.Request.Context(), role, req.UserIDs, opts...)
+			if err != nil {
+				log.Printf("StartAssignRoleToUsers failed: %v", err)
+				c.JSON(500, gin.H{"error": "start_failed"})
+				return
+			}
+			c.JSON(202, gin.H{"job_id": jobID})
+			return
+		}
+		results := svc.AssignRoleToUsers(c.Request.Context(), role, req.UserIDs, opts...)
+		failed := 0
+		for _, r := range results {
+			if r.Status == service.ItemFailed {
+				failed++
+			}
+		}
+		code := http.StatusOK
+		if failed > 0 {
+			code = http.StatusMultiStatus
+		}
+		c.JSON(code, gin.H{"role": role, "results": results, "succeeded": len(results) - failed, "failed": failed})
+	})
+
+	api.GET("/roles/:role/permissions", func(c *gin.Context) {
+		role := c.Param("role")
+		perms, err := svc.GetRolePermissions(c.Request.Context(), role)
+		if err != nil {
+			log.Printf("GetRolePermissions failed: %v", err)
+			c.JSON(500, gin.H{"error": "fetch_failed"})
+			return
+		}
+		c.JSON(200, gin.H{"role": role, "permissions": perms})
+	})
+
+	api.PUT("/roles/:role/permissions", func(c *gin.Context) {
 		var req struct {
-			Name string `json:"name" binding:"required"`
-			Desc string `json:"desc"`
+			Permissions []string `json:"permissions"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(400, gin.H{"error": "invalid"})
 			return
 		}
-		id, err := svc.CreateRole(c.Request.Context(), req.Name, req.Desc)
+		if err := svc.SetRolePermissions(c.Request.Context(), c.Param("role"), req.Permissions); err != nil {
+			log.Printf("SetRolePermissions failed: %v", err)
+			c.JSON(400, gin.H{"error": "update_failed", "detail": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"ok": true})
+	})
+
+	api.POST("/roles/:role/permissions", func(c *gin.Context) {
+		var req struct {
+			Permissions []string `json:"permissions" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "invalid"})
+			return
+		}
+		if err := svc.AddRolePermissions(c.Request.Context(), c.Param("role"), req.Permissions); err != nil {
+			log.Printf("AddRolePermissions failed: %v", err)
+			c.JSON(400, gin.H{"error": "update_failed", "detail": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"ok": true})
+	})
+
+	api.DELETE("/roles/:role/permissions/:permission", func(c *gin.Context) {
+		if err := svc.RemoveRolePermissions(c.Request.Context(), c.Param("role"), []string{c.Param("permission")}); err != nil {
+			log.Printf("RemoveRolePermissions failed: %v", err)
+			c.JSON(500, gin.H{"error": "update_failed"})
+			return
+		}
+		c.JSON(200, gin.H{"ok": true})
+	})
+
+	// body is a YAML or JSON manifest; dry_run only returns the plan
+	api.POST("/roles/sync", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid"})
+			return
+		}
+		m, err := rolesync.ParseManifest(body)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid", "detail": err.Error()})
+			return
+		}
+		dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+		prune, _ := strconv.ParseBool(c.Query("prune"))
+		plan, err := svc.PlanRoleSync(c.Request.Context(), m, rolesync.Options{Prune: prune})
+		if err != nil {
+			log.Printf("PlanRoleSync failed: %v", err)
+			c.JSON(500, gin.H{"error": "plan_failed"})
+			return
+		}
+		if dryRun || plan.Empty() {
+			c.JSON(200, gin.H{"dry_run": dryRun, "plan": plan, "diff": plan.String()})
+			return
+		}
+		result, err := svc.ApplyRoleSync(c.Request.Context(), plan)
+		if err != nil {
+			log.Printf("ApplyRoleSync failed: %v", err)
+			c.JSON(500, gin.H{"error": "sync_failed", "plan": plan, "diff": plan.String(), "result": result})
+			return
+		}
+		c.JSON(200, gin.H{"dry_run": false, "plan": plan, "diff": plan.String(), "result": result})
+	})
+
+	api.GET("/roles/hierarchy", func(c *gin.Context) {
+		g, err := svc.GetRoleHierarchy(c.Request.Context())
+		if err != nil {
+			log.Printf("GetRoleHierarchy failed: %v", err)
+			c.JSON(500, gin.H{"error": "fetch_failed"})
+			return
+		}
+		c.JSON(200, g)
+	})
+
+	api.GET("/roles/:role/implies", func(c *gin.Context) {
+		role := c.Param("role")
+		g, err := svc.GetRoleHierarchy(c.Request.Context())
+		if err != nil {
+			log.Printf("GetRoleHierarchy failed: %v", err)
+			c.JSON(500, gin.H{"error": "fetch_failed"})
+			return
+		}
+		implies, effective := g.Implies[role], g.Closure[role]
+		if implies == nil {
+			implies = []string{}
+		}
+		if effective == nil {
+			effective = []string{}
+		}
+		c.JSON(200, gin.H{"role": role, "implies": implies, "effective": effective})
+	})
+
+	api.PUT("/roles/:role/implies", func(c *gin.Context) {
+		var req struct {
+			Implies []string `json:"implies"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "invalid"})
+			return
+		}
+		if err := svc.SetRoleImplies(c.Request.Context(), c.Param("role"), req.Implies); err != nil {
+			var cycle *service.CycleError
+			if errors.As(err, &cycle) {
+				c.JSON(409, gin.H{"error": "inheritance_cycle", "cycle": cycle.Path})
+				return
+			}
+			log.Printf("SetRoleImplies failed: %v", err)
+			c.JSON(400, gin.H{"error": "update_failed", "detail": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"ok": true})
+	})
+
+	api.GET("/roles", func(c *gin.Context) {
+		q := zitadel.RoleQuery{
+			Key:         c.Query("key"),
+			DisplayName: c.Query("display_name"),
+			SortBy:      c.DefaultQuery("sort", zitadel.RoleSortKey),
+			Desc:        c.Query("order") == "desc",
+		}
+		if q.SortBy != zitadel.RoleSortKey && q.SortBy != zitadel.RoleSortDisplayName {
+			c.JSON(400, gin.H{"error": "invalid", "detail": "sort must be key or display_name"})
+			return
+		}
+		q.Limit, _ = strconv.Atoi(c.Query("limit"))
+		if q.Limit <= 0 || q.Limit > 500 {
+			q.Limit = 50
+		}
+		if cur := c.Query("cursor"); cur != "" {
+			off, ok := decodeOffsetCursor(cur)
+			if !ok {
+				c.JSON(400, gin.H{"error": "invalid", "detail": "invalid cursor"})
+				return
+			}
+			q.Offset = off
+		}
+		list, err := svc.ListRoles(c.Request.Context(), q)
+		if err != nil {
+			log.Printf("ListRoles failed: %v", err)
+			c.JSON(500, gin.H{"error": "fetch_failed"})
+			return
+		}
+		resp := gin.H{"roles": list.Roles, "total": list.Total}
+		if next := q.Offset + len(list.Roles); next < list.Total {
+			resp["next_cursor"] = encodeOffsetCursor(next)
+		}
+		c.JSON(200, resp)
+	})
+
+	api.POST("/roles", func(c *gin.Context) {
+		var req zitadel.RoleInput
+		if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+			c.JSON(400, gin.H{"error": "invalid"})
+			return
+		}
+		ids, err := svc.CreateRoles(c.Request.Context(), []zitadel.RoleInput{req})
 		if err != nil {
-			log.Printf("CreateRole failed: %v", err)
+			log.Printf("CreateRoles failed: %v", err)
 			c.JSON(500, gin.H{"error": "create_failed"})
 			return
 		}
-		c.JSON(201, gin.H{"role_id": id})
+		c.JSON(201, gin.H{"role_id": ids[0]})
+	})
+
+	api.PATCH("/roles/:role", func(c *gin.Context) {
+		var req struct {
+			DisplayName *string `json:"display_name"`
+			Group       *string `json:"group"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || (req.DisplayName == nil && req.Group == nil) {
+			c.JSON(400, gin.H{"error": "invalid", "detail": "display_name or group is required"})
+			return
+		}
+		role, err := svc.UpdateRole(c.Request.Context(), c.Param("role"), service.RoleUpdate{DisplayName: req.DisplayName, Group: req.Group})
+		if errors.Is(err, service.ErrRoleNotFound) {
+			c.JSON(404, gin.H{"error": "not_found"})
+			return
+		}
+		if err != nil {
+			log.Printf("UpdateRole failed: %v", err)
+			c.JSON(500, gin.H{"error": "update_failed"})
+			return
+		}
+		c.JSON(200, role)
 	})
 
 	api.POST("/roles/assign", func(c *gin.Context) {
 		var req struct {
-			RoleID string `json:"role_id" binding:"required"`
-			UserID string `json:"user_id" binding:"required"`
+			RoleID    string     `json:"role_id" binding:"required"`
+			UserID    string     `json:"user_id" binding:"required"`
+			ExpiresAt *time.Time `json:"expires_at"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(400, gin.H{"error": "invalid"})
 			return
 		}
-		if err := svc.AssignRole(c.Request.Context(), req.RoleID, req.UserID); err != nil {
+		opts, ok := assignOptions(c, req.ExpiresAt)
+		if !ok {
+			return
+		}
+		if err := svc.AssignRole(c.Request.Context(), req.RoleID, req.UserID, opts...); err != nil {
 			log.Printf("AssignRole failed: %v", err)
 			c.JSON(500, gin.H{"error": "assign_failed"})
 			return
@@ -146,23 +506,58 @@ func main() {
 		c.JSON(200, gin.H{"ok": true})
 	})
 
-	api.POST("/webhook/zitadel", func(c *gin.Context) {
-		var evt struct {
-			UserID string `json:"user_id"`
-			Type   string `json:"type"`
-			Role   string `json:"role,omitempty"`
+	api.GET("/assignments/expiring", func(c *gin.Context) {
+		list, err := svc.ListExpiringAssignments(c.Request.Context())
+		if err != nil {
+			log.Printf("ListExpiringAssignments failed: %v", err)
+			c.JSON(500, gin.H{"error": "fetch_failed"})
+			return
 		}
-		if err := c.ShouldBindJSON(&evt); err != nil {
-			c.JSON(400, gin.H{"error": "invalid"})
+		c.JSON(200, gin.H{"assignments": list})
+	})
+
+	api.GET("/assignments/history", func(c *gin.Context) {
+		limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "100"), 10, 64)
+		list, err := svc.GetAssignmentHistory(c.Request.Context(), limit)
+		if err != nil {
+			log.Printf("GetAssignmentHistory failed: %v", err)
+			c.JSON(500, gin.H{"error": "fetch_failed"})
 			return
 		}
-		if evt.UserID != "" {
-			_ = svc.InvalidateRoles(c.Request.Context(), evt.UserID)
+		c.JSON(200, gin.H{"assignments": list})
+	})
+
+	api.GET("/audit", func(c *gin.Context) {
+		f := audit.Filter{
+			Actor:   c.Query("actor"),
+			Action:  c.Query("action"),
+			Role:    c.Query("role"),
+			UserID:  c.Query("user_id"),
+			Outcome: c.Query("outcome"),
+			Cursor:  c.Query("cursor"),
 		}
-		if evt.Type == "role.deleted" && evt.Role != "" {
-			_, _ = svc.StartRemoveRoleCleanup(c.Request.Context(), evt.Role)
+		f.Limit, _ = strconv.Atoi(c.Query("limit"))
+		for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+			if v := c.Query(name); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					c.JSON(400, gin.H{"error": "invalid", "detail": name + " must be RFC3339"})
+					return
+				}
+				*dst = t
+			}
 		}
-		c.Status(200)
+		page, err := svc.QueryAudit(c.Request.Context(), f)
+		if errors.Is(err, audit.ErrInvalidCursor) {
+			c.JSON(400, gin.H{"error": "invalid", "detail": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("QueryAudit failed: %v", err)
+			c.JSON(500, gin.H{"error": "fetch_failed"})
+			return
+		}
+		c.JSON(200, page)
 	})
 
 	api.POST("/roles/remove/async", func(c *gin.Context) {
@@ -188,17 +583,141 @@ func main() {
 			c.JSON(400, gin.H{"error": "missing job id"})
 			return
 		}
-		status, err := svc.GetCleanupJobStatus(c.Request.Context(), jobID)
+		if status, err := svc.GetCleanupJobStatus(c.Request.Context(), jobID); err == nil {
+			c.JSON(200, status)
+			return
+		}
+		job, err := svc.GetBulkAssignJob(c.Request.Context(), jobID)
 		if err != nil {
 			c.JSON(404, gin.H{"error": "not_found"})
 			return
 		}
-		c.JSON(200, status)
+		c.JSON(200, job)
 	})
 
-	r.GET("/v1/me/profile", middleware.RoleMiddleware(svc), func(c *gin.Context) {
+	r.GET("/v1/me/profile", roleMW, func(c *gin.Context) {
 		rolesI, _ := c.Get(middleware.ContextRolesKey)
-		c.JSON(200, gin.H{"user": c.GetHeader("X-User-ID"), "roles": rolesI})
+		c.JSON(200, gin.H{"user": c.GetString(middleware.ContextUserIDKey), "roles": rolesI})
+	})
+
+	r.GET("/v1/me/permissions", roleMW, func(c *gin.Context) {
+		userID := c.GetString(middleware.ContextUserIDKey)
+		perms, err := svc.GetUserPermissions(middleware.PrincipalContext(c.Request.Context()), userID)
+		if err != nil {
+			log.Printf("GetUserPermissions failed: %v", err)
+			c.JSON(500, gin.H{"error": "fetch_failed"})
+			return
+		}
+		c.JSON(200, gin.H{"user": userID, "permissions": perms})
+	})
+
+	// access requests: any authenticated user may ask for a role, approvers
+	// configured in ACCESS_APPROVERS decide
+	r.GET("/v1/me/access-requests", roleMW, func(c *gin.Context) {
+		list, err := svc.ListAccessRequests(c.Request.Context(), cache.AccessRequestFilter{
+			UserID: c.GetString(middleware.ContextUserIDKey),
+			Status: c.Query("status"),
+		})
+		if err != nil {
+			log.Printf("ListAccessRequests failed: %v", err)
+			c.JSON(500, gin.H{"error": "fetch_failed"})
+			return
+		}
+		c.JSON(200, gin.H{"requests": list})
+	})
+
+	access := r.Group("/v1/access-requests", roleMW, orgMW, projectMW)
+
+	access.POST("", func(c *gin.Context) {
+		var req struct {
+			Role          string `json:"role" binding:"required"`
+			Justification string `json:"justification" binding:"required"`
+			Duration      string `json:"duration"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "invalid"})
+			return
+		}
+		ar, err := svc.SubmitAccessRequest(c.Request.Context(), c.GetString(middleware.ContextUserIDKey), req.Role, req.Justification, req.Duration)
+		if err != nil {
+			accessRequestError(c, "SubmitAccessRequest", err)
+			return
+		}
+		c.JSON(201, ar)
+	})
+
+	access.GET("", func(c *gin.Context) {
+		if !svc.IsAccessApprover(callerRoles(c)) {
+			c.JSON(403, gin.H{"error": "forbidden"})
+			return
+		}
+		limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "100"), 10, 64)
+		list, err := svc.ListVisibleAccessRequests(c.Request.Context(), c.GetString(middleware.ContextUserIDKey), callerRoles(c), cache.AccessRequestFilter{
+			UserID: c.Query("user_id"),
+			Status: c.Query("status"),
+			Limit:  limit,
+		})
+		if err != nil {
+			log.Printf("ListAccessRequests failed: %v", err)
+			c.JSON(500, gin.H{"error": "fetch_failed"})
+			return
+		}
+		c.JSON(200, gin.H{"requests": list})
+	})
+
+	access.GET("/:id", func(c *gin.Context) {
+		ar, err := svc.GetAccessRequest(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			accessRequestError(c, "GetAccessRequest", err)
+			return
+		}
+		if ar.UserID != c.GetString(middleware.ContextUserIDKey) && !svc.CanApprove(callerRoles(c), ar.Role) {
+			c.JSON(404, gin.H{"error": "not_found"})
+			return
+		}
+		c.JSON(200, ar)
+	})
+
+	access.POST("/:id/approve", func(c *gin.Context) {
+		var req struct {
+			Comment   string     `json:"comment"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(400, gin.H{"error": "invalid"})
+			return
+		}
+		ar, err := svc.ApproveAccessRequest(c.Request.Context(), c.Param("id"), c.GetString(middleware.ContextUserIDKey), callerRoles(c), req.Comment, req.ExpiresAt)
+		if err != nil {
+			accessRequestError(c, "ApproveAccessRequest", err)
+			return
+		}
+		c.JSON(200, ar)
+	})
+
+	access.POST("/:id/reject", func(c *gin.Context) {
+		var req struct {
+			Comment string `json:"comment"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(400, gin.H{"error": "invalid"})
+			return
+		}
+		ar, err := svc.RejectAccessRequest(c.Request.Context(), c.Param("id"), c.GetString(middleware.ContextUserIDKey), callerRoles(c), req.Comment)
+		if err != nil {
+			accessRequestError(c, "RejectAccessRequest", err)
+			return
+		}
+		c.JSON(200, ar)
+	})
+
+	access.POST("/:id/cancel", func(c *gin.Context) {
+		ar, err := svc.CancelAccessRequest(c.Request.Context(), c.Param("id"), c.GetString(middleware.ContextUserIDKey))
+		if err != nil {
+			accessRequestError(c, "CancelAccessRequest", err)
+			return
+		}
+		c.JSON(200, ar)
 	})
 	// -----------------------------------------------------------------------------
 
@@ -215,15 +734,35 @@ func main() {
 
 	srv := &http.Server{
 		Addr:         ":" + cfg.Port,
-		Handler:      r,
+		Handler:      middleware.OrgPath(middleware.ProjectPath(r)),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 20 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// client certificates are verified when offered so RoleMiddleware can trust
+	// X-User-ID from allowed mTLS peers (TRUSTED_CLIENT_CERTS)
+	if cfg.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCAFile)
+		if err != nil {
+			log.Fatalf("read TLS_CLIENT_CA_FILE: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("TLS_CLIENT_CA_FILE contains no certificates")
+		}
+		srv.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	}
+
 	go func() {
 		log.Printf("starting on :%s", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.TLSCertFile != "" {
+			err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("server failed: %v", err)
 		}
 	}()
@@ -240,3 +779,64 @@ func main() {
 	}
 	log.Println("server exited cleanly")
 }
+
+// assignOptions turns an optional expires_at into service options, answering
+// 400 itself when the time is not in the future.
+func assignOptions(c *gin.Context, expiresAt *time.Time) ([]service.AssignOption, bool) {
+	if expiresAt == nil || expiresAt.IsZero() {
+		return nil, true
+	}
+	if !expiresAt.After(time.Now()) {
+		c.JSON(400, gin.H{"error": "invalid", "detail": "expires_at must be in the future"})
+		return nil, false
+	}
+	return []service.AssignOption{service.WithExpiry(*expiresAt)}, true
+}
+
+// encodeOffsetCursor and decodeOffsetCursor keep list offsets opaque to
+// clients.
+func encodeOffsetCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeOffsetCursor(cursor string) (int, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(b), "offset:") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(b), "offset:"))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// batchStatus answers 207 Multi-Status when some items of a batch failed.
+func batchStatus(res *service.BatchResult) int {
+	if res.Failed > 0 {
+		return http.StatusMultiStatus
+	}
+	return http.StatusOK
+}
+
+func callerRoles(c *gin.Context) []string {
+	roles, _ := c.Get(middleware.ContextRolesKey)
+	list, _ := roles.([]string)
+	return list
+}
+
+func accessRequestError(c *gin.Context, op string, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidAccessRequest):
+		c.JSON(400, gin.H{"error": "invalid", "detail": err.Error()})
+	case errors.Is(err, service.ErrAccessRequestNotFound):
+		c.JSON(404, gin.H{"error": "not_found"})
+	case errors.Is(err, service.ErrNotApprover), errors.Is(err, service.ErrSelfApproval):
+		c.JSON(403, gin.H{"error": "forbidden", "detail": err.Error()})
+	case errors.Is(err, service.ErrRequestNotPending), errors.Is(err, service.ErrDuplicateRequest):
+		c.JSON(409, gin.H{"error": "conflict", "detail": err.Error()})
+	default:
+		log.Printf("%s failed: %v", op, err)
+		c.JSON(500, gin.H{"error": "access_request_failed"})
+	}
+}
@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is one role mutation. ID is assigned by the sink and identifies the
+// event's position in it.
+type Event struct {
+	ID        string            `json:"id,omitempty"`
+	Time      time.Time         `json:"time"`
+	Actor     string            `json:"actor"`
+	Action    string            `json:"action"`
+	Role      string            `json:"role,omitempty"`
+	UserID    string            `json:"user_id,omitempty"`
+	Outcome   string            `json:"outcome"`
+	Error     string            `json:"error,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// Filter narrows a query. Empty fields match everything; Cursor is the
+// NextCursor of a previous page.
+type Filter struct {
+	Actor   string
+	Action  string
+	Role    string
+	UserID  string
+	Outcome string
+	Since   time.Time
+	Until   time.Time
+	Cursor  string
+	Limit   int
+}
+
+type Page struct {
+	Events     []Event `json:"events"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// Sink stores events append-only and returns them newest first.
+type Sink interface {
+	Write(ctx context.Context, evt Event) error
+	Query(ctx context.Context, f Filter) (*Page, error)
+}
+
+func (f Filter) limit() int {
+	if f.Limit <= 0 || f.Limit > 500 {
+		return 100
+	}
+	return f.Limit
+}
+
+func (f Filter) Match(evt Event) bool {
+	switch {
+	case f.Actor != "" && evt.Actor != f.Actor,
+		f.Action != "" && evt.Action != f.Action,
+		f.Role != "" && evt.Role != f.Role,
+		f.UserID != "" && evt.UserID != f.UserID,
+		f.Outcome != "" && evt.Outcome != f.Outcome,
+		!f.Since.IsZero() && evt.Time.Before(f.Since),
+		!f.Until.IsZero() && evt.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+type actorKey struct{}
+type requestIDKey struct{}
+
+// WithActor records who performs the mutations made with ctx.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFrom(ctx context.Context) string {
+	a, _ := ctx.Value(actorKey{}).(string)
+	return a
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewEvent fills in time, actor, request ID and outcome from ctx and err.
+func NewEvent(ctx context.Context, action, role, userID string, err error) Event {
+	evt := Event{
+		Time:      time.Now().UTC(),
+		Actor:     ActorFrom(ctx),
+		Action:    action,
+		Role:      role,
+		UserID:    userID,
+		Outcome:   OutcomeSuccess,
+		RequestID: RequestIDFrom(ctx),
+	}
+	if evt.Actor == "" {
+		evt.Actor = "anonymous"
+	}
+	if err != nil {
+		evt.Outcome = OutcomeFailure
+		evt.Error = err.Error()
+	}
+	return evt
+}
+
+type nopSink struct{}
+
+// Nop discards events; it is the default when no sink is configured.
+func Nop() Sink { return nopSink{} }
+
+func (nopSink) Write(context.Context, Event) error { return nil }
+
+func (nopSink) Query(context.Context, Filter) (*Page, error) {
+	return &Page{Events: []Event{}}, nil
+}
@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// FileSink appends events as JSON lines. An event's ID is the byte offset of
+// its line, so queries read the file backwards from the cursor, and stop
+// once the page is full.
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{path: path, f: f}, nil
+}
+
+func (s *FileSink) Write(ctx context.Context, evt Event) error {
+	evt.ID = ""
+	b, _ := json.Marshal(evt)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.f.Write(append(b, '\n'))
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+func (s *FileSink) Query(ctx context.Context, f Filter) (*Page, error) {
+	limit := f.limit()
+	end := int64(-1)
+	if f.Cursor != "" {
+		n, err := strconv.ParseInt(f.Cursor, 10, 64)
+		if err != nil || n < 0 {
+			return nil, ErrInvalidCursor
+		}
+		end = n
+	}
+
+	r, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	st, err := r.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if end < 0 || end > st.Size() {
+		end = st.Size()
+	}
+
+	page := &Page{Events: []Event{}}
+	lines := &backwardLines{r: r, off: end}
+	for {
+		line, start, err := lines.next()
+		if err != nil {
+			return nil, err
+		}
+		if line == nil {
+			break
+		}
+
+		var evt Event
+		if err := json.Unmarshal(line, &evt); err != nil {
+			continue
+		}
+		evt.ID = strconv.FormatInt(start, 10)
+		if !f.Since.IsZero() && evt.Time.Before(f.Since) {
+			break
+		}
+		if !f.Match(evt) {
+			continue
+		}
+		if len(page.Events) == limit {
+			page.NextCursor = page.Events[limit-1].ID
+			break
+		}
+		page.Events = append(page.Events, evt)
+	}
+	return page, nil
+}
+
+const backwardBlockSize = 64 << 10
+
+// backwardLines reads the lines of r that end before off, last line first,
+// a block at a time, so a query only reads as far back as its page needs.
+type backwardLines struct {
+	r   io.ReaderAt
+	off int64
+	// buf holds the data read from off on that has not been returned yet
+	buf []byte
+}
+
+// next returns the previous non-empty line and its offset, or a nil line
+// once the start of the file is reached.
+func (b *backwardLines) next() ([]byte, int64, error) {
+	for {
+		data := bytes.TrimRight(b.buf, "\n")
+		if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+			b.buf = data[:i+1]
+			return data[i+1:], b.off + int64(i+1), nil
+		}
+		if b.off == 0 {
+			b.buf = nil
+			if len(data) == 0 {
+				return nil, 0, nil
+			}
+			return data, 0, nil
+		}
+		n := int64(backwardBlockSize)
+		if n > b.off {
+			n = b.off
+		}
+		block := make([]byte, n+int64(len(data)))
+		if _, err := b.r.ReadAt(block[:n], b.off-n); err != nil {
+			return nil, 0, err
+		}
+		copy(block[n:], data)
+		b.off -= n
+		b.buf = block
+	}
+}
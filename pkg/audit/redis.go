@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisSink appends events to a Redis stream capped at roughly maxLen
+// entries. Stream IDs double as event IDs and cursors.
+type RedisSink struct {
+	rdb    *redis.Client
+	stream string
+	maxLen int64
+}
+
+func NewRedisSink(rdb *redis.Client, stream string, maxLen int64) *RedisSink {
+	if stream == "" {
+		stream = "audit:events"
+	}
+	return &RedisSink{rdb: rdb, stream: stream, maxLen: maxLen}
+}
+
+func (s *RedisSink) Write(ctx context.Context, evt Event) error {
+	evt.ID = ""
+	b, _ := json.Marshal(evt)
+	args := &redis.XAddArgs{Stream: s.stream, Values: map[string]interface{}{"event": b}}
+	if s.maxLen > 0 {
+		args.MaxLen = s.maxLen
+		args.Approx = true
+	}
+	return s.rdb.XAdd(ctx, args).Err()
+}
+
+func (s *RedisSink) Query(ctx context.Context, f Filter) (*Page, error) {
+	limit := f.limit()
+	end, start := "+", "-"
+	if !f.Until.IsZero() {
+		end = strconv.FormatInt(f.Until.UnixMilli(), 10)
+	}
+	if !f.Since.IsZero() {
+		start = strconv.FormatInt(f.Since.UnixMilli(), 10)
+	}
+	if f.Cursor != "" {
+		if !validStreamID(f.Cursor) {
+			return nil, ErrInvalidCursor
+		}
+		end = "(" + f.Cursor
+	}
+
+	page := &Page{Events: []Event{}}
+	batch := int64(limit * 2)
+	for {
+		msgs, err := s.rdb.XRevRangeN(ctx, s.stream, end, start, batch).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i, m := range msgs {
+			raw, _ := m.Values["event"].(string)
+			var evt Event
+			if err := json.Unmarshal([]byte(raw), &evt); err != nil {
+				continue
+			}
+			evt.ID = m.ID
+			if !f.Match(evt) {
+				continue
+			}
+			page.Events = append(page.Events, evt)
+			if len(page.Events) == limit {
+				if i < len(msgs)-1 || int64(len(msgs)) == batch {
+					page.NextCursor = m.ID
+				}
+				return page, nil
+			}
+		}
+		if int64(len(msgs)) < batch {
+			return page, nil
+		}
+		end = "(" + msgs[len(msgs)-1].ID
+	}
+}
+
+func validStreamID(id string) bool {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return false
+	}
+	if _, err := strconv.ParseUint(ms, 10, 64); err != nil {
+		return false
+	}
+	_, err := strconv.ParseUint(seq, 10, 64)
+	return err == nil
+}
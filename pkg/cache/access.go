@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrAccessRequestNotFound  = errors.New("access request not found")
+	ErrDuplicateAccessRequest = errors.New("a pending request for this role already exists")
+)
+
+type AccessRequestEvent struct {
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action"`
+	Comment string    `json:"comment,omitempty"`
+}
+
+// AccessRequest is a user's request for a role. Status moves from "pending" to
+// "approved", "rejected" or "cancelled"; History records every transition.
+type AccessRequest struct {
+	ID            string `json:"id"`
+	UserID        string `json:"user_id"`
+	Role          string `json:"role"`
+	Justification string `json:"justification"`
+	// Duration is the requested length of the grant ("8h"); empty asks for
+	// a permanent one.
+	Duration string `json:"duration,omitempty"`
+	// OrgID and ProjectID are where the request was submitted, and where
+	// the role is granted on approval.
+	OrgID     string               `json:"org_id,omitempty"`
+	ProjectID string               `json:"project_id,omitempty"`
+	Status    string               `json:"status"`
+	DecidedBy string               `json:"decided_by,omitempty"`
+	ExpiresAt *time.Time           `json:"expires_at,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+	History   []AccessRequestEvent `json:"history"`
+}
+
+type AccessRequestFilter struct {
+	UserID string
+	Status string
+	// Match, when set, keeps only the requests it accepts; Limit counts the
+	// kept ones.
+	Match func(*AccessRequest) bool
+	Limit int64
+}
+
+func accessRequestKey(id string) string {
+	return "access_request:" + id
+}
+
+// access requests are indexed by creation time, overall and per user and
+// status, so listing never has to scan
+func accessIndexKeys(r *AccessRequest) []string {
+	return []string{
+		"access_requests:all",
+		"access_requests:user:" + r.UserID,
+		"access_requests:status:" + r.Status,
+	}
+}
+
+// pendingAccessKey marks the pending request of a user for a role in a
+// project, so there is at most one.
+func pendingAccessKey(r *AccessRequest) string {
+	return projectKey(r.ProjectID, "access_requests:pending:"+r.UserID+"/"+r.Role)
+}
+
+// createAccessRequest stores the request KEYS[1] and adds it to the indexes
+// KEYS[3...] unless the ID is taken (0) or, when ARGV[4] is "1", the
+// pending marker KEYS[2] is already set (-1), in which case it claims it.
+var createAccessRequest = redis.NewScript(`
+if ARGV[4] == '1' and redis.call('EXISTS', KEYS[2]) == 1 then
+	return -1
+end
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2])
+if ARGV[4] == '1' then
+	redis.call('SET', KEYS[2], ARGV[1])
+end
+for i = 3, #KEYS do
+	redis.call('ZADD', KEYS[i], ARGV[3], ARGV[1])
+end
+return 1
+`)
+
+// CreateAccessRequest stores a new request. A pending one fails with
+// ErrDuplicateAccessRequest when the user already has a pending request for
+// the role in the same project; the check and the write are one atomic step.
+func (c *redisCache) CreateAccessRequest(ctx context.Context, r *AccessRequest) error {
+	if r.ID == "" {
+		r.ID = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	b, _ := json.Marshal(r)
+	pending := "0"
+	if r.Status == "pending" {
+		pending = "1"
+	}
+	keys := append([]string{accessRequestKey(r.ID), pendingAccessKey(r)}, accessIndexKeys(r)...)
+	n, err := createAccessRequest.Run(ctx, c.rdb, keys, r.ID, b, r.CreatedAt.UnixNano(), pending).Int()
+	if err != nil {
+		return err
+	}
+	switch n {
+	case -1:
+		return ErrDuplicateAccessRequest
+	case 0:
+		return fmt.Errorf("access request %s already exists", r.ID)
+	}
+	return nil
+}
+
+func (c *redisCache) GetAccessRequest(ctx context.Context, id string) (*AccessRequest, error) {
+	return c.readAccessRequest(ctx, c.rdb, id)
+}
+
+func (c *redisCache) readAccessRequest(ctx context.Context, cmd redis.Cmdable, id string) (*AccessRequest, error) {
+	b, err := cmd.Get(ctx, accessRequestKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrAccessRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r AccessRequest
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// UpdateAccessRequest applies fn to the stored request and saves it
+// atomically, so two approvers cannot both decide the same request. The
+// status index and pending marker follow the new status.
+func (c *redisCache) UpdateAccessRequest(ctx context.Context, id string, fn func(*AccessRequest) error) (*AccessRequest, error) {
+	key := accessRequestKey(id)
+	var out *AccessRequest
+	for attempt := 0; attempt < 5; attempt++ {
+		err := c.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			r, err := c.readAccessRequest(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			oldStatus := r.Status
+			if err := fn(r); err != nil {
+				return err
+			}
+			pendingKey := pendingAccessKey(r)
+			if err := tx.Watch(ctx, pendingKey).Err(); err != nil {
+				return err
+			}
+			marker, err := tx.Get(ctx, pendingKey).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			b, _ := json.Marshal(r)
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, b, 0)
+				if r.Status != oldStatus {
+					pipe.ZRem(ctx, "access_requests:status:"+oldStatus, id)
+					pipe.ZAdd(ctx, "access_requests:status:"+r.Status, redis.Z{Score: float64(r.CreatedAt.UnixNano()), Member: id})
+					switch {
+					case oldStatus == "pending" && marker == id:
+						pipe.Del(ctx, pendingKey)
+					case r.Status == "pending":
+						pipe.Set(ctx, pendingKey, id, 0)
+					}
+				}
+				return nil
+			})
+			out = r
+			return err
+		}, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return out, err
+	}
+	return nil, errors.New("access request update conflicted too many times")
+}
+
+// ListAccessRequests returns the newest requests first. UserID and Status
+// narrow the result; when both are set the user's requests are filtered.
+func (c *redisCache) ListAccessRequests(ctx context.Context, f AccessRequestFilter) ([]*AccessRequest, error) {
+	limit := f.Limit
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	index := "access_requests:all"
+	switch {
+	case f.UserID != "":
+		index = "access_requests:user:" + f.UserID
+	case f.Status != "":
+		index = "access_requests:status:" + f.Status
+	}
+	out := []*AccessRequest{}
+	var start int64
+	for int64(len(out)) < limit {
+		ids, err := c.rdb.ZRevRange(ctx, index, start, start+limit-1).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		start += int64(len(ids))
+		for _, id := range ids {
+			r, err := c.GetAccessRequest(ctx, id)
+			if errors.Is(err, ErrAccessRequestNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if f.Status != "" && r.Status != f.Status {
+				continue
+			}
+			if f.Match != nil && !f.Match(r) {
+				continue
+			}
+			out = append(out, r)
+			if int64(len(out)) == limit {
+				break
+			}
+		}
+	}
+	return out, nil
+}
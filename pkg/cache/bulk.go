@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	bulkAssignJobPrefix     = "job:bulk_assign:"
+	bulkAssignResultsSuffix = ":results"
+	bulkAssignJobTTL        = 24 * time.Hour
+)
+
+var ErrBulkJobNotFound = errors.New("job not found")
+
+// BulkAssignResult is the outcome of granting the job's role to one user.
+type BulkAssignResult struct {
+	UserID string `json:"user_id"`
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkAssignJob tracks a background assignment of one role to many users.
+// Results are filled in as users are processed. They are stored apart from
+// the job record, one hash field per user, so progress updates only write
+// what changed.
+type BulkAssignJob struct {
+	JobID      string             `json:"job_id"`
+	Type       string             `json:"type"`
+	Role       string             `json:"role"`
+	ProjectID  string             `json:"project_id,omitempty"`
+	Status     string             `json:"status"`
+	Total      int                `json:"total"`
+	Processed  int                `json:"processed"`
+	Succeeded  int                `json:"succeeded"`
+	Failed     int                `json:"failed"`
+	Results    []BulkAssignResult `json:"results"`
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt time.Time          `json:"finished_at,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// SaveBulkAssignJob stores the job's current state and results, keyed by
+// the user's index in the job; job.Results is ignored and results saved
+// earlier are kept. Both are kept for 24h after the last update, like the
+// cleanup jobs.
+func (c *redisCache) SaveBulkAssignJob(ctx context.Context, job *BulkAssignJob, results map[int]BulkAssignResult) error {
+	rec := *job
+	rec.Results = nil
+	b, err := json.Marshal(&rec)
+	if err != nil {
+		return err
+	}
+	key := bulkAssignJobPrefix + job.JobID
+	pipe := c.rdb.TxPipeline()
+	pipe.Set(ctx, key, b, bulkAssignJobTTL)
+	if len(results) > 0 {
+		fields := make(map[string]interface{}, len(results))
+		for i, r := range results {
+			rb, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			fields[strconv.Itoa(i)] = rb
+		}
+		pipe.HSet(ctx, key+bulkAssignResultsSuffix, fields)
+	}
+	pipe.Expire(ctx, key+bulkAssignResultsSuffix, bulkAssignJobTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (c *redisCache) GetBulkAssignJob(ctx context.Context, jobID string) (*BulkAssignJob, error) {
+	key := bulkAssignJobPrefix + jobID
+	b, err := c.rdb.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrBulkJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job BulkAssignJob
+	if err := json.Unmarshal(b, &job); err != nil {
+		return nil, err
+	}
+	fields, err := c.rdb.HGetAll(ctx, key+bulkAssignResultsSuffix).Result()
+	if err != nil {
+		return nil, err
+	}
+	job.Results = make([]BulkAssignResult, job.Total)
+	for k, v := range fields {
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 || i >= job.Total {
+			continue
+		}
+		_ = json.Unmarshal([]byte(v), &job.Results[i])
+	}
+	return &job, nil
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"github.com/AbduAllahGabbar/service/pkg/scope"
 )
 
 type Cache interface {
@@ -16,6 +18,32 @@ type Cache interface {
 	RemoveRoleFromAllCaches(ctx context.Context, role string) (int, error)
 	StartRemoveRoleJob(ctx context.Context, role string) (string, error)
 	GetJobStatus(ctx context.Context, jobID string) (*CleanupJobStatus, error)
+	GetTokenEntry(ctx context.Context, token string) (*TokenEntry, bool, error)
+	SetTokenEntry(ctx context.Context, token string, entry *TokenEntry, ttl time.Duration) error
+	GetRolePermissions(ctx context.Context, role string) ([]string, error)
+	SetRolePermissions(ctx context.Context, role string, perms []string) error
+	AddRolePermissions(ctx context.Context, role string, perms []string) error
+	RemoveRolePermissions(ctx context.Context, role string, perms []string) error
+	DeleteRolePermissions(ctx context.Context, role string) error
+	PermissionsForRoles(ctx context.Context, roles []string) ([]string, error)
+	PermissionsVersion(ctx context.Context) (int64, error)
+	GetUserPermissions(ctx context.Context, version int64, userID string) ([]string, bool, error)
+	SetUserPermissions(ctx context.Context, version int64, userID string, perms []string, ttl time.Duration) error
+	GetRoleGraph(ctx context.Context) (*RoleGraph, error)
+	UpdateRoleGraph(ctx context.Context, fn func(*RoleGraph) error) error
+	ScheduleRoleExpiry(ctx context.Context, a *RoleAssignment) error
+	CancelRoleExpiry(ctx context.Context, roleID, userID string) (bool, error)
+	GetRoleAssignment(ctx context.Context, roleID, userID string) (*RoleAssignment, bool, error)
+	ListScheduledExpiries(ctx context.Context) ([]*RoleAssignment, error)
+	ClaimExpiredAssignments(ctx context.Context, now time.Time, lease time.Duration, limit int64) ([]*RoleAssignment, error)
+	RecordAssignmentOutcome(ctx context.Context, a *RoleAssignment) error
+	GetAssignmentHistory(ctx context.Context, limit int64) ([]*RoleAssignment, error)
+	CreateAccessRequest(ctx context.Context, r *AccessRequest) error
+	GetAccessRequest(ctx context.Context, id string) (*AccessRequest, error)
+	UpdateAccessRequest(ctx context.Context, id string, fn func(*AccessRequest) error) (*AccessRequest, error)
+	ListAccessRequests(ctx context.Context, f AccessRequestFilter) ([]*AccessRequest, error)
+	SaveBulkAssignJob(ctx context.Context, job *BulkAssignJob, results map[int]BulkAssignResult) error
+	GetBulkAssignJob(ctx context.Context, jobID string) (*BulkAssignJob, error)
 }
 
 type rolesValue struct {
@@ -33,23 +61,30 @@ type CleanupJobStatus struct {
 	StartedAt time.Time `json:"started_at"`
 	FinishedAt time.Time `json:"finished_at,omitempty"`
 	Error     string    `json:"error,omitempty"`
+	ProjectID string    `json:"project_id,omitempty"`
 }
 
 type redisCache struct {
 	rdb        *redis.Client
 	defaultTTL time.Duration
+	// project is the default project, see WithDefaultProject.
+	project string
 }
 
-func NewRedisCache(rdb *redis.Client, defaultTTL time.Duration) Cache {
-	return &redisCache{rdb: rdb, defaultTTL: defaultTTL}
+func NewRedisCache(rdb *redis.Client, defaultTTL time.Duration, opts ...Option) Cache {
+	c := &redisCache{rdb: rdb, defaultTTL: defaultTTL}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-func (c *redisCache) key(userID string) string {
-	return fmt.Sprintf("roles:%s", userID)
+func (c *redisCache) key(ctx context.Context, userID string) string {
+	return c.scoped(ctx, fmt.Sprintf("roles:%s", userID))
 }
 
 func (c *redisCache) GetRoles(ctx context.Context, userID string) ([]string, bool, error) {
-	b, err := c.rdb.Get(ctx, c.key(userID)).Bytes()
+	b, err := c.rdb.Get(ctx, c.key(ctx, userID)).Bytes()
 	if err == redis.Nil {
 		return nil, false, nil
 	}
@@ -69,11 +104,14 @@ func (c *redisCache) SetRoles(ctx context.Context, userID string, roles []string
 	if ttl == 0 {
 		ttl = c.defaultTTL
 	}
-	return c.rdb.Set(ctx, c.key(userID), b, ttl).Err()
+	return c.rdb.Set(ctx, c.key(ctx, userID), b, ttl).Err()
 }
 
 func (c *redisCache) InvalidateRoles(ctx context.Context, userID string) error {
-	return c.rdb.Del(ctx, c.key(userID)).Err()
+	if err := c.rdb.Del(ctx, c.key(ctx, userID)).Err(); err != nil {
+		return err
+	}
+	return c.invalidateUserPermissions(ctx, userID)
 }
 
 func (c *redisCache) RemoveRoleFromAllCaches(ctx context.Context, role string) (int, error) {
@@ -81,7 +119,7 @@ func (c *redisCache) RemoveRoleFromAllCaches(ctx context.Context, role string) (
 	updated := 0
 	batchSize := int64(100)
 	for {
-		keys, cur, err := c.rdb.Scan(ctx, cursor, "roles:*", batchSize).Result()
+		keys, cur, err := c.rdb.Scan(ctx, cursor, c.scoped(ctx, "roles:*"), batchSize).Result()
 		if err != nil {
 			return updated, err
 		}
@@ -159,13 +197,13 @@ func (c *redisCache) RemoveRoleFromAllCaches(ctx context.Context, role string) (
 
 func (c *redisCache) StartRemoveRoleJob(ctx context.Context, role string) (string, error) {
 	jobID := fmt.Sprintf("%d", time.Now().UnixNano())
-	status := CleanupJobStatus{JobID: jobID, Role: role, Processed: 0, Updated: 0, Status: "running", StartedAt: time.Now()}
+	status := CleanupJobStatus{JobID: jobID, Role: role, Processed: 0, Updated: 0, Status: "running", StartedAt: time.Now(), ProjectID: c.projectOf(ctx)}
 	b, _ := json.Marshal(status)
 	if err := c.rdb.Set(ctx, "job:roles_cleanup:"+jobID, b, 24*time.Hour).Err(); err != nil {
 		return "", err
 	}
 	go func(j string, r string) {
-		_ = c.runRemoveRoleJob(context.Background(), j, r)
+		_ = c.runRemoveRoleJob(scope.WithProjectID(context.Background(), scope.ProjectIDFrom(ctx)), j, r)
 	}(jobID, role)
 	return jobID, nil
 }
@@ -176,12 +214,12 @@ func (c *redisCache) runRemoveRoleJob(ctx context.Context, jobID, role string) e
 		b, _ := json.Marshal(s)
 		return c.rdb.Set(ctx, key, b, 24*time.Hour).Err()
 	}
-	status := CleanupJobStatus{JobID: jobID, Role: role, Processed: 0, Updated: 0, Status: "running", StartedAt: time.Now()}
+	status := CleanupJobStatus{JobID: jobID, Role: role, Processed: 0, Updated: 0, Status: "running", StartedAt: time.Now(), ProjectID: c.projectOf(ctx)}
 	_ = update(status)
 	var cursor uint64
 	batchSize := int64(100)
 	for {
-		keys, cur, err := c.rdb.Scan(ctx, cursor, "roles:*", batchSize).Result()
+		keys, cur, err := c.rdb.Scan(ctx, cursor, c.scoped(ctx, "roles:*"), batchSize).Result()
 		if err != nil {
 			status.Status = "failed"
 			status.Error = err.Error()
@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Time-bound assignments are scheduled in a sorted set scored by expiry. The
+// full record lives in a hash so the schedule can be listed, and every
+// finished assignment is pushed onto a capped history list.
+const (
+	expirySchedKey   = "assign:expiry"
+	expiryRecordsKey = "assign:records"
+	expiryHistoryKey = "assign:history"
+	expiryHistoryMax = 1000
+)
+
+type RoleAssignment struct {
+	RoleID    string    `json:"role_id"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	// OrgID and ProjectID are where the grant was made, when not the
+	// defaults.
+	OrgID     string `json:"org_id,omitempty"`
+	ProjectID string `json:"project_id,omitempty"`
+	// Status is "scheduled", "expired" once the grant was removed, "failed"
+	// when removal kept failing, or "cancelled".
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	// RetryAt, when set, replaces ExpiresAt as the due time after a failed
+	// removal.
+	RetryAt time.Time `json:"retry_at,omitempty"`
+	DoneAt  time.Time `json:"done_at,omitempty"`
+
+	// lease is the schedule score set by ClaimExpiredAssignments, so
+	// RecordAssignmentOutcome only drops the entry it claimed.
+	lease int64
+}
+
+// assignmentMember identifies an assignment in the schedule and records,
+// which are shared by all projects.
+func assignmentMember(projectID, roleID, userID string) string {
+	return projectKey(projectID, userID+"/"+roleID)
+}
+
+// ScheduleRoleExpiry stores a for the project of ctx.
+func (c *redisCache) ScheduleRoleExpiry(ctx context.Context, a *RoleAssignment) error {
+	a.ProjectID = c.projectOf(ctx)
+	member := assignmentMember(a.ProjectID, a.RoleID, a.UserID)
+	due := a.ExpiresAt
+	if !a.RetryAt.IsZero() {
+		due = a.RetryAt
+	}
+	b, _ := json.Marshal(a)
+	pipe := c.rdb.TxPipeline()
+	pipe.HSet(ctx, expiryRecordsKey, member, b)
+	pipe.ZAdd(ctx, expirySchedKey, redis.Z{Score: float64(due.Unix()), Member: member})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// CancelRoleExpiry drops a pending expiry, e.g. when the grant is removed by
+// hand or replaced by a permanent one. It reports whether one was pending.
+func (c *redisCache) CancelRoleExpiry(ctx context.Context, roleID, userID string) (bool, error) {
+	member := assignmentMember(c.projectOf(ctx), roleID, userID)
+	removed, err := c.rdb.ZRem(ctx, expirySchedKey, member).Result()
+	if err != nil || removed == 0 {
+		return false, err
+	}
+	a, ok, err := c.getAssignment(ctx, member)
+	if err != nil {
+		return true, err
+	}
+	if !ok {
+		return true, nil
+	}
+	a.Status = "cancelled"
+	a.DoneAt = time.Now()
+	return true, c.RecordAssignmentOutcome(ctx, a)
+}
+
+func (c *redisCache) GetRoleAssignment(ctx context.Context, roleID, userID string) (*RoleAssignment, bool, error) {
+	return c.getAssignment(ctx, assignmentMember(c.projectOf(ctx), roleID, userID))
+}
+
+func (c *redisCache) getAssignment(ctx context.Context, member string) (*RoleAssignment, bool, error) {
+	b, err := c.rdb.HGet(ctx, expiryRecordsKey, member).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var a RoleAssignment
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, false, err
+	}
+	return &a, true, nil
+}
+
+// ListScheduledExpiries returns the pending expiries of the project of ctx.
+func (c *redisCache) ListScheduledExpiries(ctx context.Context) ([]*RoleAssignment, error) {
+	members, err := c.rdb.ZRange(ctx, expirySchedKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	project := c.projectOf(ctx)
+	out := make([]*RoleAssignment, 0, len(members))
+	for _, m := range members {
+		a, ok, err := c.getAssignment(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if ok && a.ProjectID == project {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+// claimExpired moves up to ARGV[3] entries due by ARGV[1] to score ARGV[2]
+// and returns them.
+var claimExpired = redis.NewScript(`
+local members = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[3]))
+for _, m in ipairs(members) do
+	redis.call('ZADD', KEYS[1], 'XX', ARGV[2], m)
+end
+return members
+`)
+
+// ClaimExpiredAssignments leases up to limit lapsed entries of the schedule,
+// across all projects, and returns them. A claimed entry stays scheduled,
+// due again once lease has passed, until RecordAssignmentOutcome or a new
+// ScheduleRoleExpiry replaces it, so several replicas can reap concurrently
+// without handling the same assignment twice, and an assignment whose reaper
+// died is picked up again.
+func (c *redisCache) ClaimExpiredAssignments(ctx context.Context, now time.Time, lease time.Duration, limit int64) ([]*RoleAssignment, error) {
+	until := now.Add(lease).Unix()
+	members, err := claimExpired.Run(ctx, c.rdb, []string{expirySchedKey},
+		now.Unix(), until, limit).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	var out []*RoleAssignment
+	for _, m := range members {
+		a, ok, err := c.getAssignment(ctx, m)
+		if err != nil {
+			return out, err
+		}
+		if !ok {
+			// no record to act on; drop the orphan instead of leasing it forever
+			if err := c.rdb.ZRem(ctx, expirySchedKey, m).Err(); err != nil {
+				return out, err
+			}
+			continue
+		}
+		a.lease = until
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// recordOutcome forgets the schedule entry and record unless the assignment
+// was scheduled again in the meantime (its score is no longer the claimed
+// lease ARGV[4]), then appends the outcome to the history.
+var recordOutcome = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score == false or (ARGV[4] ~= '0' and tonumber(score) == tonumber(ARGV[4])) then
+	redis.call('ZREM', KEYS[1], ARGV[1])
+	redis.call('HDEL', KEYS[2], ARGV[1])
+end
+redis.call('LPUSH', KEYS[3], ARGV[2])
+redis.call('LTRIM', KEYS[3], 0, tonumber(ARGV[3]) - 1)
+return 1
+`)
+
+// RecordAssignmentOutcome stores a finished assignment in the history of its
+// project.
+func (c *redisCache) RecordAssignmentOutcome(ctx context.Context, a *RoleAssignment) error {
+	b, _ := json.Marshal(a)
+	return recordOutcome.Run(ctx, c.rdb,
+		[]string{expirySchedKey, expiryRecordsKey, projectKey(a.ProjectID, expiryHistoryKey)},
+		assignmentMember(a.ProjectID, a.RoleID, a.UserID), b, expiryHistoryMax, a.lease).Err()
+}
+
+// GetAssignmentHistory returns the newest outcomes of the project of ctx.
+func (c *redisCache) GetAssignmentHistory(ctx context.Context, limit int64) ([]*RoleAssignment, error) {
+	if limit <= 0 || limit > expiryHistoryMax {
+		limit = expiryHistoryMax
+	}
+	vals, err := c.rdb.LRange(ctx, c.scoped(ctx, expiryHistoryKey), 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*RoleAssignment, 0, len(vals))
+	for _, v := range vals {
+		var a RoleAssignment
+		if err := json.Unmarshal([]byte(v), &a); err != nil {
+			continue
+		}
+		out = append(out, &a)
+	}
+	return out, nil
+}
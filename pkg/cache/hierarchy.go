@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const roleGraphKey = "role_graph"
+
+// RoleGraph is the role inheritance graph: Implies holds the direct edges
+// (admin -> [editor]) and Closure the precomputed transitive set for each role.
+type RoleGraph struct {
+	Implies map[string][]string `json:"implies"`
+	Closure map[string][]string `json:"closure"`
+}
+
+func (c *redisCache) GetRoleGraph(ctx context.Context) (*RoleGraph, error) {
+	return c.readRoleGraph(ctx, c.rdb)
+}
+
+func (c *redisCache) readRoleGraph(ctx context.Context, cmd redis.Cmdable) (*RoleGraph, error) {
+	g := &RoleGraph{Implies: map[string][]string{}, Closure: map[string][]string{}}
+	b, err := cmd.Get(ctx, c.scoped(ctx, roleGraphKey)).Bytes()
+	if err == redis.Nil {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, g); err != nil {
+		return nil, err
+	}
+	if g.Implies == nil {
+		g.Implies = map[string][]string{}
+	}
+	if g.Closure == nil {
+		g.Closure = map[string][]string{}
+	}
+	return g, nil
+}
+
+// UpdateRoleGraph applies fn to the current graph and stores the result
+// atomically, retrying when another writer got there first. Expanded user
+// permission caches are invalidated since they depend on inheritance.
+func (c *redisCache) UpdateRoleGraph(ctx context.Context, fn func(*RoleGraph) error) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		err := c.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			g, err := c.readRoleGraph(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if err := fn(g); err != nil {
+				return err
+			}
+			b, _ := json.Marshal(g)
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, c.scoped(ctx, roleGraphKey), b, 0)
+				pipe.Incr(ctx, permsVersionKey)
+				return nil
+			})
+			return err
+		}, c.scoped(ctx, roleGraphKey))
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return err
+	}
+	return errors.New("role graph update conflicted too many times")
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Role permissions live in one Redis set per role. Expanded per-user sets are
+// cached under the current value of permsVersionKey, which is bumped on every
+// mapping change so stale entries are simply never read again and expire.
+const permsVersionKey = "perms:version"
+
+func (c *redisCache) rolePermsKey(ctx context.Context, role string) string {
+	return c.scoped(ctx, "perms:role:"+role)
+}
+
+func (c *redisCache) userPermsKey(ctx context.Context, version int64, userID string) string {
+	return c.scoped(ctx, fmt.Sprintf("perms:user:%d:%s", version, userID))
+}
+
+// PermissionsVersion returns the current version of the role permission
+// mappings. Read it before expanding a user's permissions and store the
+// result under it, so a mapping change made meanwhile is not cached under
+// the new version.
+func (c *redisCache) PermissionsVersion(ctx context.Context) (int64, error) {
+	v, err := c.rdb.Get(ctx, permsVersionKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (c *redisCache) GetRolePermissions(ctx context.Context, role string) ([]string, error) {
+	perms, err := c.rdb.SMembers(ctx, c.rolePermsKey(ctx, role)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(perms)
+	return perms, nil
+}
+
+func (c *redisCache) SetRolePermissions(ctx context.Context, role string, perms []string) error {
+	pipe := c.rdb.TxPipeline()
+	pipe.Del(ctx, c.rolePermsKey(ctx, role))
+	if len(perms) > 0 {
+		pipe.SAdd(ctx, c.rolePermsKey(ctx, role), toInterfaces(perms)...)
+	}
+	pipe.Incr(ctx, permsVersionKey)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (c *redisCache) AddRolePermissions(ctx context.Context, role string, perms []string) error {
+	if len(perms) == 0 {
+		return nil
+	}
+	pipe := c.rdb.TxPipeline()
+	pipe.SAdd(ctx, c.rolePermsKey(ctx, role), toInterfaces(perms)...)
+	pipe.Incr(ctx, permsVersionKey)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (c *redisCache) RemoveRolePermissions(ctx context.Context, role string, perms []string) error {
+	if len(perms) == 0 {
+		return nil
+	}
+	pipe := c.rdb.TxPipeline()
+	pipe.SRem(ctx, c.rolePermsKey(ctx, role), toInterfaces(perms)...)
+	pipe.Incr(ctx, permsVersionKey)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (c *redisCache) DeleteRolePermissions(ctx context.Context, role string) error {
+	return c.SetRolePermissions(ctx, role, nil)
+}
+
+func (c *redisCache) PermissionsForRoles(ctx context.Context, roles []string) ([]string, error) {
+	if len(roles) == 0 {
+		return []string{}, nil
+	}
+	keys := make([]string, 0, len(roles))
+	for _, r := range roles {
+		keys = append(keys, c.rolePermsKey(ctx, r))
+	}
+	perms, err := c.rdb.SUnion(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(perms)
+	return perms, nil
+}
+
+func (c *redisCache) GetUserPermissions(ctx context.Context, version int64, userID string) ([]string, bool, error) {
+	b, err := c.rdb.Get(ctx, c.userPermsKey(ctx, version, userID)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var perms []string
+	if err := json.Unmarshal(b, &perms); err != nil {
+		return nil, false, err
+	}
+	return perms, true, nil
+}
+
+func (c *redisCache) SetUserPermissions(ctx context.Context, version int64, userID string, perms []string, ttl time.Duration) error {
+	if ttl == 0 {
+		ttl = c.defaultTTL
+	}
+	b, _ := json.Marshal(perms)
+	return c.rdb.Set(ctx, c.userPermsKey(ctx, version, userID), b, ttl).Err()
+}
+
+func (c *redisCache) invalidateUserPermissions(ctx context.Context, userID string) error {
+	version, err := c.PermissionsVersion(ctx)
+	if err != nil {
+		return err
+	}
+	return c.rdb.Del(ctx, c.userPermsKey(ctx, version, userID)).Err()
+}
+
+func toInterfaces(v []string) []interface{} {
+	out := make([]interface{}, len(v))
+	for i, s := range v {
+		out[i] = s
+	}
+	return out
+}
@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/AbduAllahGabbar/service/pkg/scope"
+)
+
+// Option configures NewRedisCache.
+type Option func(*redisCache)
+
+// WithDefaultProject names the project requests without a project
+// (scope.WithProjectID) belong to. Its entries keep the unprefixed keys, so
+// explicitly naming it shares the same cache.
+func WithDefaultProject(projectID string) Option {
+	return func(c *redisCache) { c.project = projectID }
+}
+
+// projectOf returns the project of ctx, or "" for the default project.
+func (c *redisCache) projectOf(ctx context.Context) string {
+	if p := scope.ProjectIDFrom(ctx); p != c.project {
+		return p
+	}
+	return ""
+}
+
+// scoped namespaces key by the project of ctx: "project:<id>:<key>" for any
+// but the default project. The prefix keeps other projects out of scans of
+// the default project's keys ("roles:*").
+func (c *redisCache) scoped(ctx context.Context, key string) string {
+	return projectKey(c.projectOf(ctx), key)
+}
+
+func projectKey(projectID, key string) string {
+	if projectID == "" {
+		return key
+	}
+	return "project:" + projectID + ":" + key
+}
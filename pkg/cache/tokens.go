@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TokenEntry is a resolved bearer token. Entries are keyed by a
+// SHA-256 of the token so raw tokens never end up in Redis.
+type TokenEntry struct {
+	Subject   string    `json:"sub,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+func (c *redisCache) tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])
+}
+
+func (c *redisCache) GetTokenEntry(ctx context.Context, token string) (*TokenEntry, bool, error) {
+	b, err := c.rdb.Get(ctx, c.tokenKey(token)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var e TokenEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, false, err
+	}
+	return &e, true, nil
+}
+
+func (c *redisCache) SetTokenEntry(ctx context.Context, token string, entry *TokenEntry, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	b, _ := json.Marshal(entry)
+	return c.rdb.Set(ctx, c.tokenKey(token), b, ttl).Err()
+}
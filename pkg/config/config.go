@@ -3,12 +3,29 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
 	ZitadelBaseURL string
 	ZitadelToken   string
+	// ZitadelAuth selects how the client obtains access tokens: static
+	// (ZitadelToken), client_credentials or jwt_profile (ZitadelKeyFile).
+	// Empty infers it from the credentials that are set.
+	ZitadelAuth         string
+	ZitadelKeyFile      string
+	ZitadelClientID     string
+	ZitadelClientSecret string
+	// ZitadelTokenURL defaults to ZitadelBaseURL + "/oauth/v2/token".
+	ZitadelTokenURL string
+	// ZitadelOrgID is the default organization of management calls; a
+	// request can override it (x-zitadel-orgid).
+	ZitadelOrgID string
+	// ZitadelOrgAccess maps an organization ("*" for any other) to the
+	// caller roles allowed to override the default organization with it
+	// ("*" for any caller).
+	ZitadelOrgAccess map[string][]string
 
 	RedisAddr     string
 	RedisPassword string
@@ -24,6 +41,32 @@ type Config struct {
 	CBMaxRequests  uint32
 
 	ProjectID      string
+	// ProjectAccess is ZitadelOrgAccess for projects other than ProjectID.
+	ProjectAccess map[string][]string
+
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+
+	PolicyFile           string
+	PolicyReloadInterval time.Duration
+
+	RoleInheritance bool
+
+	ExpiryReapInterval time.Duration
+
+	// AccessApprovers maps a requestable role ("*" for any) to the roles
+	// allowed to approve access requests for it.
+	AccessApprovers map[string][]string
+
+	AuditSink         string
+	AuditFile         string
+	AuditStreamMaxLen int64
+
+	// BulkAssignConcurrency bounds the parallel Zitadel calls of a bulk
+	// assignment; inputs above BulkAssignSyncLimit users run as a job.
+	BulkAssignConcurrency int
+	BulkAssignSyncLimit   int
 }
 
 func LoadConfig() *Config {
@@ -44,6 +87,21 @@ func LoadConfig() *Config {
 		cbTimeout = 30 * time.Second
 	}
 
+	policyReload, err := time.ParseDuration(getEnv("POLICY_RELOAD_INTERVAL", "10s"))
+	if err != nil {
+		policyReload = 10 * time.Second
+	}
+
+	expiryReap, err := time.ParseDuration(getEnv("EXPIRY_REAP_INTERVAL", "30s"))
+	if err != nil {
+		expiryReap = 30 * time.Second
+	}
+
+	roleInheritance, err := strconv.ParseBool(getEnv("ROLE_INHERITANCE", "true"))
+	if err != nil {
+		roleInheritance = true
+	}
+
 	retryMax := 3
 	if v := os.Getenv("RETRY_MAX"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
@@ -51,6 +109,27 @@ func LoadConfig() *Config {
 		}
 	}
 
+	auditMaxLen := int64(100000)
+	if v := os.Getenv("AUDIT_STREAM_MAXLEN"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			auditMaxLen = n
+		}
+	}
+
+	bulkConcurrency := 8
+	if v := os.Getenv("BULK_ASSIGN_CONCURRENCY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			bulkConcurrency = n
+		}
+	}
+
+	bulkSyncLimit := 50
+	if v := os.Getenv("BULK_ASSIGN_SYNC_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			bulkSyncLimit = n
+		}
+	}
+
 	redisDB := 0
 	if v := os.Getenv("REDIS_DB"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
@@ -61,6 +140,7 @@ func LoadConfig() *Config {
 	return &Config{
 		ZitadelBaseURL: getEnv("ZITADEL_DOMAIN", "http://localhost:8080"),
 		ZitadelToken:   os.Getenv("SERVICE_ACCOUNT_TOKEN"),
+		ZitadelKeyFile: os.Getenv("ZITADEL_KEY_FILE"),
 		RedisAddr:      getEnv("REDIS_ADDR", "localhost:6379"),
 		RedisPassword:  os.Getenv("REDIS_PASSWORD"),
 		RedisDB:        redisDB,
@@ -72,7 +152,55 @@ func LoadConfig() *Config {
 		CBTimeout:      cbTimeout,
 		CBMaxRequests:  5,
 		ProjectID:      os.Getenv("PROJECT_ID"),
+		ProjectAccess:  parseApprovers(os.Getenv("PROJECT_ACCESS")),
+
+		ZitadelAuth:         os.Getenv("ZITADEL_AUTH"),
+		ZitadelClientID:     os.Getenv("ZITADEL_CLIENT_ID"),
+		ZitadelClientSecret: os.Getenv("ZITADEL_CLIENT_SECRET"),
+		ZitadelTokenURL:     os.Getenv("ZITADEL_TOKEN_URL"),
+		ZitadelOrgID:        os.Getenv("ZITADEL_ORG_ID"),
+		ZitadelOrgAccess:    parseApprovers(os.Getenv("ZITADEL_ORG_ACCESS")),
+
+		TLSCertFile:     os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:      os.Getenv("TLS_KEY_FILE"),
+		TLSClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+
+		PolicyFile:           os.Getenv("POLICY_FILE"),
+		PolicyReloadInterval: policyReload,
+
+		RoleInheritance: roleInheritance,
+
+		ExpiryReapInterval: expiryReap,
+
+		AccessApprovers: parseApprovers(os.Getenv("ACCESS_APPROVERS")),
+
+		AuditSink:         getEnv("AUDIT_SINK", "redis"),
+		AuditFile:         getEnv("AUDIT_FILE", "audit.log"),
+		AuditStreamMaxLen: auditMaxLen,
+
+		BulkAssignConcurrency: bulkConcurrency,
+		BulkAssignSyncLimit:   bulkSyncLimit,
+	}
+}
+
+// parseApprovers reads "role=approver,approver;*=admin". ZITADEL_ORG_ACCESS
+// and PROJECT_ACCESS use the same format with organizations or projects in
+// place of requested roles.
+func parseApprovers(v string) map[string][]string {
+	out := map[string][]string{}
+	for _, rule := range strings.Split(v, ";") {
+		role, approvers, ok := strings.Cut(rule, "=")
+		role = strings.TrimSpace(role)
+		if !ok || role == "" {
+			continue
+		}
+		for _, a := range strings.Split(approvers, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				out[role] = append(out[role], a)
+			}
+		}
 	}
+	return out
 }
 
 func getEnv(key, fallback string) string {
@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AbduAllahGabbar/service/pkg/audit"
+	"github.com/AbduAllahGabbar/service/pkg/service"
+)
+
+// Principal is the authenticated caller with the roles fetched for it in
+// Project ("" for the default project). ClientID and Scopes are only known
+// for bearer-token requests.
+type Principal struct {
+	UserID   string
+	Roles    []string
+	Project  string
+	ClientID string
+	Scopes   []string
+}
+
+type principalKey struct{}
+
+// WithPrincipal stores p on ctx and makes it the actor of audited mutations
+// done with the returned context.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	if p != nil {
+		ctx = audit.WithActor(ctx, p.UserID)
+	}
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// AuthError is returned when a caller cannot be authenticated. Status is the
+// HTTP status adapters should answer with and Message the public error code.
+type AuthError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *AuthError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+func (e *AuthError) body() map[string]interface{} {
+	b := map[string]interface{}{"error": e.Message}
+	if e.Err != nil && !errors.Is(e.Err, ErrInactiveToken) {
+		b["detail"] = e.Err.Error()
+	}
+	return b
+}
+
+type Options struct {
+	Resolver TokenResolver
+	// Trust decides who may assert a user via X-User-ID. When nil the header
+	// is always ignored and a bearer token is required.
+	Trust *TrustPolicy
+	// Project is where caller roles are looked up, in the default org,
+	// whatever project or org the request itself targets. Empty means the
+	// Zitadel client's default project.
+	Project string
+}
+
+// Authenticator resolves the caller and its roles independently of the
+// transport; the gin, net/http and gRPC adapters all sit on top of it.
+type Authenticator struct {
+	svc  *service.Service
+	opts Options
+}
+
+func NewAuthenticator(svc *service.Service, opts Options) *Authenticator {
+	return &Authenticator{svc: svc, opts: opts}
+}
+
+// NewAuthenticatorFromEnv wires the resolver chain, token cache, X-User-ID
+// trust policy and AUTHZ_PROJECT_ID from the environment, as RoleMiddleware
+// does.
+func NewAuthenticatorFromEnv(svc *service.Service) *Authenticator {
+	zitadelDomain := strings.TrimRight(os.Getenv("ZITADEL_DOMAIN"), "/")
+	if zitadelDomain == "" {
+		log.Println("warning: ZITADEL_DOMAIN is not set (RoleMiddleware will fail for opaque tokens)")
+	}
+	resolver, err := NewTokenResolverFromEnv(zitadelDomain)
+	if err != nil {
+		// falling back to userinfo would silently drop the checks the
+		// operator configured (introspection, local JWT validation)
+		log.Fatalf("RoleMiddleware: %v", err)
+	}
+	maxTTL := durationFromEnv("TOKEN_CACHE_TTL", time.Minute)
+	if maxTTL > 0 {
+		resolver = NewCachingResolver(resolver, svc, maxTTL,
+			durationFromEnv("TOKEN_NEGATIVE_TTL", 10*time.Second), intFromEnv("TOKEN_NEGATIVE_MAX", 10000))
+	}
+	trust, err := TrustPolicyFromEnv()
+	if err != nil {
+		log.Printf("warning: %v (X-User-ID will not be trusted)\n", err)
+		trust = nil
+	}
+	return NewAuthenticator(svc, Options{Resolver: resolver, Trust: trust, Project: os.Getenv("AUTHZ_PROJECT_ID")})
+}
+
+// Trust exposes the X-User-ID trust policy for adapters that check it
+// against their own transport metadata.
+func (a *Authenticator) Trust() *TrustPolicy {
+	return a.opts.Trust
+}
+
+// AuthenticateRequest authenticates an HTTP request from a trusted X-User-ID
+// header or its bearer token. An untrusted X-User-ID is removed from r.
+func (a *Authenticator) AuthenticateRequest(r *http.Request) (*Principal, error) {
+	userID := strings.TrimSpace(r.Header.Get(HeaderUserID))
+	if userID != "" && !a.opts.Trust.Trusted(r, userID) {
+		log.Printf("RoleMiddleware: ignoring untrusted X-User-ID from %s\n", r.RemoteAddr)
+		r.Header.Del(HeaderUserID)
+		userID = ""
+	}
+	if userID != "" {
+		return a.AuthenticateUser(r.Context(), userID)
+	}
+	return a.AuthenticateAuthorization(r.Context(), r.Header.Get("Authorization"))
+}
+
+// AuthenticateAuthorization authenticates an "Authorization: Bearer ..."
+// header value.
+func (a *Authenticator) AuthenticateAuthorization(ctx context.Context, header string) (*Principal, error) {
+	auth := strings.TrimSpace(header)
+	if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
+		log.Println("RoleMiddleware: missing Authorization bearer")
+		return nil, &AuthError{Status: http.StatusUnauthorized, Message: "missing bearer token"}
+	}
+	return a.AuthenticateToken(ctx, strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+}
+
+func (a *Authenticator) AuthenticateToken(ctx context.Context, token string) (*Principal, error) {
+	info, err := a.opts.Resolver.Resolve(ctx, token)
+	if errors.Is(err, ErrInactiveToken) {
+		log.Println("RoleMiddleware: token is not active")
+		return nil, &AuthError{Status: http.StatusUnauthorized, Message: "inactive token", Err: err}
+	}
+	if err != nil || info.Subject == "" {
+		if err == nil {
+			err = fmt.Errorf("token has no subject")
+		}
+		log.Printf("RoleMiddleware: failed to resolve user from token: %v\n", err)
+		return nil, &AuthError{Status: http.StatusUnauthorized, Message: "invalid token", Err: err}
+	}
+	log.Printf("RoleMiddleware: resolved user id %s from token\n", info.Subject)
+
+	p, err := a.AuthenticateUser(ctx, info.Subject)
+	if err != nil {
+		return nil, err
+	}
+	p.ClientID = info.ClientID
+	p.Scopes = info.Scopes
+	return p, nil
+}
+
+// AuthenticateUser loads the roles of an already identified user. Callers
+// are responsible for having established that userID can be trusted.
+func (a *Authenticator) AuthenticateUser(ctx context.Context, userID string) (*Principal, error) {
+	roles, err := a.svc.GetUserRoles(authzContext(ctx, a.opts.Project), userID)
+	if err != nil {
+		log.Printf("RoleMiddleware: GetUserRoles failed for %s: %v\n", userID, err)
+		return nil, &AuthError{Status: http.StatusInternalServerError, Message: "failed to fetch roles", Err: err}
+	}
+	return &Principal{UserID: userID, Roles: roles, Project: a.opts.Project}, nil
+}
@@ -0,0 +1,119 @@
+package grpcauth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/AbduAllahGabbar/service/pkg/audit"
+	"github.com/AbduAllahGabbar/service/pkg/middleware"
+)
+
+// UnaryServerInterceptor authenticates every call from its "authorization"
+// metadata (or a trusted "x-user-id") and stores the caller as a
+// middleware.Principal on the context. requirements maps full method names
+// ("/pkg.Service/Method") to role checks; methods not listed only require
+// authentication.
+func UnaryServerInterceptor(a *middleware.Authenticator, requirements map[string]middleware.Requirement) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, a, info.FullMethod, requirements)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamServerInterceptor(a *middleware.Authenticator, requirements map[string]middleware.Requirement) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), a, info.FullMethod, requirements)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
+}
+
+func authenticate(ctx context.Context, a *middleware.Authenticator, fullMethod string, requirements map[string]middleware.Requirement) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	userID := strings.TrimSpace(get(middleware.HeaderUserID))
+	if userID != "" {
+		var addr string
+		var state *tls.ConnectionState
+		if p, ok := peer.FromContext(ctx); ok {
+			addr = p.Addr.String()
+			if ti, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				state = &ti.State
+			}
+		}
+		if !a.Trust().TrustedPeer(addr, "POST", fullMethod, get, state, userID) {
+			log.Printf("grpcauth: ignoring untrusted x-user-id from %s\n", addr)
+			userID = ""
+		}
+	}
+
+	var p *middleware.Principal
+	var err error
+	if userID != "" {
+		p, err = a.AuthenticateUser(ctx, userID)
+	} else {
+		p, err = a.AuthenticateAuthorization(ctx, get("authorization"))
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	if req, ok := requirements[fullMethod]; ok {
+		if evt := req.Check(p.Roles); evt != nil {
+			evt.UserID = p.UserID
+			evt.Method = "GRPC"
+			evt.Path = fullMethod
+			middleware.EmitDenial(*evt)
+			if len(evt.Forbidden) > 0 {
+				return nil, status.Errorf(codes.PermissionDenied, "forbidden: roles %v are not allowed", evt.Forbidden)
+			}
+			return nil, status.Errorf(codes.PermissionDenied, "forbidden: missing roles %v", evt.Missing)
+		}
+	}
+	if rid := get("x-request-id"); rid != "" {
+		ctx = audit.WithRequestID(ctx, rid)
+	}
+	return middleware.WithPrincipal(ctx, p), nil
+}
+
+func toStatus(err error) error {
+	var ae *middleware.AuthError
+	if errors.As(err, &ae) {
+		if ae.Status == http.StatusUnauthorized {
+			return status.Error(codes.Unauthenticated, ae.Message)
+		}
+		return status.Error(codes.Internal, ae.Message)
+	}
+	return status.Error(codes.Internal, "authentication failed")
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DenialEvent describes a request rejected by a role guard.
+type DenialEvent struct {
+	Time      time.Time `json:"time"`
+	Guard     string    `json:"guard"`
+	Rule      string    `json:"rule,omitempty"`
+	UserID    string    `json:"user_id"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Required  []string  `json:"required"`
+	Missing   []string  `json:"missing,omitempty"`
+	Forbidden []string  `json:"forbidden,omitempty"`
+}
+
+var (
+	denialMu        sync.RWMutex
+	denialListeners []func(DenialEvent)
+)
+
+// OnDenial registers fn to receive every guard denial, e.g. to feed metrics
+// or an audit trail. Denials are always logged regardless.
+func OnDenial(fn func(DenialEvent)) {
+	denialMu.Lock()
+	defer denialMu.Unlock()
+	denialListeners = append(denialListeners, fn)
+}
+
+// EmitDenial logs evt and hands it to the OnDenial listeners. Adapters for
+// other transports call it so denials look the same everywhere.
+func EmitDenial(evt DenialEvent) {
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+	b, _ := json.Marshal(evt)
+	log.Printf("access denied: %s\n", b)
+
+	denialMu.RLock()
+	listeners := denialListeners
+	denialMu.RUnlock()
+	for _, fn := range listeners {
+		fn(evt)
+	}
+}
+
+// Requirement is a role check shared by the gin, net/http and gRPC adapters.
+type Requirement struct {
+	guard string
+	roles []string
+}
+
+func AnyRole(roles ...string) Requirement {
+	return Requirement{guard: "require_any_role", roles: roles}
+}
+
+func AllRoles(roles ...string) Requirement {
+	return Requirement{guard: "require_all_roles", roles: roles}
+}
+
+func NoneOf(roles ...string) Requirement {
+	return Requirement{guard: "require_none_of", roles: roles}
+}
+
+// Check returns nil when userRoles satisfy the requirement, otherwise a
+// partially filled DenialEvent naming the missing or forbidden roles.
+func (r Requirement) Check(userRoles []string) *DenialEvent {
+	evt := &DenialEvent{Guard: r.guard, Required: r.roles}
+	switch r.guard {
+	case "require_any_role":
+		if HasAnyRole(userRoles, r.roles...) {
+			return nil
+		}
+		evt.Missing = r.roles
+	case "require_all_roles":
+		if evt.Missing = missingRoles(userRoles, r.roles); len(evt.Missing) == 0 {
+			return nil
+		}
+	case "require_none_of":
+		if evt.Forbidden = presentRoles(userRoles, r.roles); len(evt.Forbidden) == 0 {
+			return nil
+		}
+	}
+	return evt
+}
+
+// RequireAnyRole lets the request through when the user holds at least one of
+// roles. It must run after RoleMiddleware.
+func RequireAnyRole(roles ...string) gin.HandlerFunc {
+	return Require(AnyRole(roles...))
+}
+
+// RequireAllRoles lets the request through only when the user holds every
+// one of roles. It must run after RoleMiddleware.
+func RequireAllRoles(roles ...string) gin.HandlerFunc {
+	return Require(AllRoles(roles...))
+}
+
+// RequireNoneOf rejects users holding any of roles. It must run after
+// RoleMiddleware.
+func RequireNoneOf(roles ...string) gin.HandlerFunc {
+	return Require(NoneOf(roles...))
+}
+
+func Require(req Requirement) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userRoles, ok := rolesFromContext(c)
+		if !ok {
+			return
+		}
+		if evt := req.Check(userRoles); evt != nil {
+			deny(c, *evt)
+			return
+		}
+		c.Next()
+	}
+}
+
+func HasAllRoles(userRoles []string, rolesToCheck ...string) bool {
+	return len(missingRoles(userRoles, rolesToCheck)) == 0
+}
+
+func rolesFromContext(c *gin.Context) ([]string, bool) {
+	v, exists := c.Get(ContextRolesKey)
+	if !exists {
+		log.Println("role guard used without RoleMiddleware")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "roles not resolved"})
+		return nil, false
+	}
+	roles, _ := v.([]string)
+	return roles, true
+}
+
+func deny(c *gin.Context, evt DenialEvent) {
+	evt.UserID = c.GetString(ContextUserIDKey)
+	evt.Method = c.Request.Method
+	evt.Path = c.FullPath()
+	if evt.Path == "" {
+		evt.Path = c.Request.URL.Path
+	}
+	EmitDenial(evt)
+	c.AbortWithStatusJSON(http.StatusForbidden, denialBody(evt))
+}
+
+func denialBody(evt DenialEvent) map[string]interface{} {
+	kind := "roles"
+	if evt.Guard == "require_permission" {
+		kind = "permissions"
+	}
+	body := map[string]interface{}{"error": "forbidden", "required_" + kind: evt.Required}
+	if evt.Rule != "" {
+		body["rule"] = evt.Rule
+	}
+	if len(evt.Missing) > 0 {
+		body["missing_"+kind] = evt.Missing
+	}
+	if len(evt.Forbidden) > 0 {
+		body["forbidden_roles"] = evt.Forbidden
+	}
+	return body
+}
+
+func missingRoles(userRoles, required []string) []string {
+	roleSet := make(map[string]struct{}, len(userRoles))
+	for _, r := range userRoles {
+		roleSet[r] = struct{}{}
+	}
+	var missing []string
+	for _, r := range required {
+		if _, ok := roleSet[r]; !ok {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+func presentRoles(userRoles, roles []string) []string {
+	roleSet := make(map[string]struct{}, len(userRoles))
+	for _, r := range userRoles {
+		roleSet[r] = struct{}{}
+	}
+	var present []string
+	for _, r := range roles {
+		if _, ok := roleSet[r]; ok {
+			present = append(present, r)
+		}
+	}
+	return present
+}
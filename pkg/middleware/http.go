@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// HTTPMiddleware is the net/http counterpart of GinMiddleware: the caller is
+// available to next through PrincipalFromContext(r.Context()).
+func (a *Authenticator) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.AuthenticateRequest(r)
+		if err != nil {
+			var ae *AuthError
+			if errors.As(err, &ae) {
+				writeJSON(w, ae.Status, ae.body())
+				return
+			}
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "authentication failed"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+// RequireHTTP enforces req for handlers wrapped by HTTPMiddleware.
+func RequireHTTP(req Requirement) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "roles not resolved"})
+				return
+			}
+			if evt := req.Check(p.Roles); evt != nil {
+				evt.UserID = p.UserID
+				evt.Method = r.Method
+				evt.Path = r.URL.Path
+				EmitDenial(*evt)
+				writeJSON(w, http.StatusForbidden, denialBody(*evt))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
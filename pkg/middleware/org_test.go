@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AbduAllahGabbar/service/pkg/scope"
+	"github.com/AbduAllahGabbar/service/pkg/zitadel"
+)
+
+func TestOrgContext(t *testing.T) {
+	access := map[string][]string{"o1": {"admin"}, "*": {"*"}}
+	tests := []struct {
+		name    string
+		roles   []string // nil: RoleMiddleware did not run
+		org     string
+		want    int
+		wantOrg string
+	}{
+		{name: "no header", want: http.StatusOK},
+		{name: "default org", org: "home", want: http.StatusOK, wantOrg: "home"},
+		{name: "listed org with role", roles: []string{"admin"}, org: "o1", want: http.StatusOK, wantOrg: "o1"},
+		{name: "listed org without role", roles: []string{"viewer"}, org: "o1", want: http.StatusForbidden},
+		{name: "wildcard org", roles: []string{}, org: "o2", want: http.StatusOK, wantOrg: "o2"},
+		{name: "wildcard org without roles resolved", org: "o2", want: http.StatusInternalServerError},
+		{name: "invalid id", roles: []string{"admin"}, org: "o/1", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			var gotOrg string
+			r.GET("/v1/roles", func(c *gin.Context) {
+				if tt.roles != nil {
+					c.Set(ContextRolesKey, tt.roles)
+				}
+			}, OrgContext(access, "home"), func(c *gin.Context) {
+				gotOrg = scope.OrgIDFrom(c.Request.Context())
+				c.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest("GET", "/v1/roles", nil)
+			if tt.org != "" {
+				req.Header.Set(zitadel.HeaderOrgID, tt.org)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d", w.Code, tt.want)
+			}
+			if gotOrg != tt.wantOrg {
+				t.Fatalf("org %q, want %q", gotOrg, tt.wantOrg)
+			}
+		})
+	}
+}
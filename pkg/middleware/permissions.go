@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AbduAllahGabbar/service/pkg/service"
+)
+
+const ContextPermissionsKey = "user_permissions"
+
+// RequirePermission lets the request through only when the user's roles
+// grant every one of perms, in the project the roles were resolved in. It
+// must run after RoleMiddleware; the expanded permission set is stored under
+// ContextPermissionsKey.
+func RequirePermission(svc *service.Service, perms ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString(ContextUserIDKey)
+		if userID == "" {
+			log.Println("RequirePermission used without RoleMiddleware")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "roles not resolved"})
+			return
+		}
+		granted, err := svc.GetUserPermissions(PrincipalContext(c.Request.Context()), userID)
+		if err != nil {
+			log.Printf("RequirePermission: GetUserPermissions failed for %s: %v\n", userID, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch permissions"})
+			return
+		}
+		c.Set(ContextPermissionsKey, granted)
+
+		if missing := missingRoles(granted, perms); len(missing) > 0 {
+			deny(c, DenialEvent{Guard: "require_permission", Required: perms, Missing: missing})
+			return
+		}
+		c.Next()
+	}
+}
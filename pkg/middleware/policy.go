@@ -0,0 +1,280 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+
+	"github.com/AbduAllahGabbar/service/pkg/service"
+)
+
+// Policy maps method+route patterns to the roles allowed to call them. Rules
+// are evaluated in order and the first match decides; Default ("allow" or
+// "deny") applies when nothing matches.
+type Policy struct {
+	Default string       `yaml:"default" json:"default"`
+	Rules   []PolicyRule `yaml:"rules" json:"rules"`
+}
+
+// PolicyRule matches Methods ("*" or empty for all) and Path, a concrete path
+// such as /v1/roles/admin or a gin route template such as /v1/roles/:role or
+// /v1/admin/*rest. The user needs every
+// role in All and at least one in Any; a rule with neither admits any
+// authenticated user.
+type PolicyRule struct {
+	Name    string   `yaml:"name" json:"name"`
+	Methods []string `yaml:"methods" json:"methods"`
+	Path    string   `yaml:"path" json:"path"`
+	Any     []string `yaml:"any" json:"any"`
+	All     []string `yaml:"all" json:"all"`
+}
+
+type Decision struct {
+	Allowed    bool     `json:"allowed"`
+	Rule       string   `json:"rule,omitempty"`
+	Reason     string   `json:"reason"`
+	Roles      []string `json:"roles"`
+	MissingAll []string `json:"missing_all,omitempty"`
+	MissingAny []string `json:"missing_any,omitempty"`
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is valid YAML, so one decoder covers both formats
+	var p Policy
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("decode policy %s: %w", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	p.Default = strings.ToLower(strings.TrimSpace(p.Default))
+	if p.Default == "" {
+		p.Default = "deny"
+	}
+	if p.Default != "allow" && p.Default != "deny" {
+		return fmt.Errorf("default must be allow or deny, got %q", p.Default)
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("rule %d: path must start with /", i)
+		}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rules[%d]", i)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) Evaluate(method, path string, roles []string) Decision {
+	for _, r := range p.Rules {
+		if !r.matches(method, path) {
+			continue
+		}
+		d := Decision{Rule: r.Name, Roles: roles}
+		d.MissingAll = missingRoles(roles, r.All)
+		if len(r.Any) > 0 && !HasAnyRole(roles, r.Any...) {
+			d.MissingAny = r.Any
+		}
+		switch {
+		case len(d.MissingAll) > 0:
+			d.Reason = fmt.Sprintf("rule %s requires all of %v", r.Name, r.All)
+		case len(d.MissingAny) > 0:
+			d.Reason = fmt.Sprintf("rule %s requires any of %v", r.Name, r.Any)
+		default:
+			d.Allowed = true
+			d.Reason = fmt.Sprintf("rule %s is satisfied", r.Name)
+		}
+		return d
+	}
+	return Decision{
+		Allowed: p.Default == "allow",
+		Roles:   roles,
+		Reason:  fmt.Sprintf("no rule matches %s %s, default is %s", method, path, p.Default),
+	}
+}
+
+func (r PolicyRule) matches(method, path string) bool {
+	if len(r.Methods) > 0 {
+		ok := false
+		for _, m := range r.Methods {
+			if m == "*" || strings.EqualFold(m, method) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return matchRoute(r.Path, path)
+}
+
+// matchRoute matches a gin route template against either a concrete path or
+// another template (c.FullPath()), so ":role" in both positions lines up.
+func matchRoute(pattern, path string) bool {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	xs := strings.Split(strings.Trim(path, "/"), "/")
+	for i, p := range ps {
+		if i >= len(xs) {
+			return false
+		}
+		if strings.HasPrefix(p, "*") {
+			return true
+		}
+		if strings.HasPrefix(p, ":") {
+			if xs[i] == "" {
+				return false
+			}
+			continue
+		}
+		if p != xs[i] {
+			return false
+		}
+	}
+	return len(ps) == len(xs)
+}
+
+// PolicyEngine holds the active policy and swaps it when the file changes.
+type PolicyEngine struct {
+	path string
+
+	mu      sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+}
+
+func NewPolicyEngine(path string) (*PolicyEngine, error) {
+	e := &PolicyEngine{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *PolicyEngine) Reload() error {
+	st, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	p, err := LoadPolicy(e.path)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.policy = p
+	e.modTime = st.ModTime()
+	e.mu.Unlock()
+	return nil
+}
+
+// Watch polls the policy file every interval and reloads it when its
+// modification time changes. A broken file is logged and the previous policy
+// stays active.
+func (e *PolicyEngine) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			st, err := os.Stat(e.path)
+			if err != nil {
+				log.Printf("policy: stat %s failed: %v\n", e.path, err)
+				continue
+			}
+			e.mu.RLock()
+			changed := !st.ModTime().Equal(e.modTime)
+			e.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := e.Reload(); err != nil {
+				log.Printf("policy: reload failed, keeping previous policy: %v\n", err)
+				continue
+			}
+			log.Printf("policy: reloaded %s\n", e.path)
+		}
+	}
+}
+
+func (e *PolicyEngine) Policy() *Policy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.policy
+}
+
+func (e *PolicyEngine) Evaluate(method, path string, roles []string) Decision {
+	return e.Policy().Evaluate(method, path, roles)
+}
+
+// Middleware enforces the policy for the requested path. Rules see the
+// concrete path rather than the gin route template, so a rule for
+// /v1/roles/admin applies as well as one for /v1/roles/:role. It must run
+// after RoleMiddleware.
+func (e *PolicyEngine) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roles, ok := rolesFromContext(c)
+		if !ok {
+			return
+		}
+		d := e.Evaluate(c.Request.Method, c.Request.URL.Path, roles)
+		if d.Allowed {
+			c.Next()
+			return
+		}
+		required := append(append([]string{}, d.MissingAll...), d.MissingAny...)
+		deny(c, DenialEvent{Guard: "policy", Rule: d.Rule, Required: required, Missing: required})
+	}
+}
+
+// ExplainHandler is a dry-run endpoint: it reports whether method+path would
+// be allowed for a user (roles from Service.GetUserRoles) or an explicit role
+// list, without performing the request. Without either it explains the
+// caller's own access.
+func (e *PolicyEngine) ExplainHandler(svc *service.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Method string   `json:"method" binding:"required"`
+			Path   string   `json:"path" binding:"required"`
+			UserID string   `json:"user_id"`
+			Roles  []string `json:"roles"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid"})
+			return
+		}
+		roles := req.Roles
+		switch {
+		case req.UserID != "":
+			r, err := svc.GetUserRoles(PrincipalContext(c.Request.Context()), req.UserID)
+			if err != nil {
+				log.Printf("policy explain: GetUserRoles failed for %s: %v", req.UserID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch roles"})
+				return
+			}
+			roles = r
+		case roles == nil:
+			v, _ := c.Get(ContextRolesKey)
+			roles, _ = v.([]string)
+		}
+		c.JSON(http.StatusOK, e.Evaluate(strings.ToUpper(req.Method), req.Path, roles))
+	}
+}
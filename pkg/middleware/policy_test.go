@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPolicyMiddlewareConcretePath(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	policy := `
+default: deny
+rules:
+  - name: admin-role
+    methods: [DELETE]
+    path: /v1/roles/admin
+    any: [super-admin]
+  - name: roles
+    path: /v1/roles/:role
+    any: [admin]
+`
+	if err := os.WriteFile(file, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewPolicyEngine(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(ContextUserIDKey, "u1")
+		c.Set(ContextRolesKey, []string{"admin"})
+	}, engine.Middleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/v1/roles/:role", ok)
+	r.DELETE("/v1/roles/:role", ok)
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{method: "DELETE", path: "/v1/roles/admin", want: http.StatusForbidden},
+		{method: "DELETE", path: "/v1/roles/viewer", want: http.StatusOK},
+		{method: "GET", path: "/v1/roles/admin", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
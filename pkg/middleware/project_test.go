@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProjectAccess(t *testing.T) {
+	access := map[string][]string{"p2": {"p2-admin"}, "*": {"platform-admin"}}
+	tests := []struct {
+		name  string
+		path  string
+		roles []string // nil: RoleMiddleware did not run
+		want  int
+	}{
+		{name: "unscoped", path: "/v1/roles", roles: []string{}, want: http.StatusOK},
+		{name: "default project", path: "/v1/projects/p1/roles", roles: []string{}, want: http.StatusOK},
+		{name: "listed project with role", path: "/v1/projects/p2/roles", roles: []string{"p2-admin"}, want: http.StatusOK},
+		{name: "listed project with another project's role", path: "/v1/projects/p2/roles", roles: []string{"admin"}, want: http.StatusForbidden},
+		{name: "other project with wildcard role", path: "/v1/projects/p3/roles", roles: []string{"platform-admin"}, want: http.StatusOK},
+		{name: "other project", path: "/v1/projects/p3/roles", roles: []string{"p2-admin"}, want: http.StatusForbidden},
+		{name: "roles not resolved", path: "/v1/projects/p3/roles", want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/v1/roles", func(c *gin.Context) {
+				if tt.roles != nil {
+					c.Set(ContextRolesKey, tt.roles)
+				}
+			}, ProjectAccess(access, "p1"), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			ProjectPath(r).ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
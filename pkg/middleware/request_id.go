@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AbduAllahGabbar/service/pkg/audit"
+)
+
+const HeaderRequestID = "X-Request-ID"
+
+// RequestID propagates the caller's X-Request-ID, or generates one, into the
+// response header and the request context, where audit events pick it up.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := strings.TrimSpace(c.GetHeader(HeaderRequestID))
+		if id == "" || len(id) > 128 {
+			b := make([]byte, 16)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		c.Header(HeaderRequestID, id)
+		c.Request = c.Request.WithContext(audit.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
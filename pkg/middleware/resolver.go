@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AbduAllahGabbar/service/pkg/oidc"
+)
+
+var (
+	ErrInactiveToken = errors.New("token is not active")
+	ErrInvalidToken  = errors.New("invalid token")
+
+	// ErrNoAudience means local JWT validation is on without an audience to
+	// check, which would accept tokens issued to any client.
+	ErrNoAudience = errors.New("JWT_VALIDATION=local needs JWT_AUDIENCE or PROJECT_ID")
+)
+
+// TokenInfo is what a TokenResolver knows about a bearer token.
+type TokenInfo struct {
+	Subject   string
+	ClientID  string
+	Scopes    []string
+	ExpiresAt time.Time
+}
+
+// TokenResolver turns a bearer token into the identity behind it. Tokens the
+// issuer refuses yield ErrInactiveToken or wrap ErrInvalidToken; any other
+// error is treated as transient.
+type TokenResolver interface {
+	Resolve(ctx context.Context, token string) (*TokenInfo, error)
+}
+
+type userinfoResolver struct {
+	zitadelDomain string
+}
+
+// NewUserinfoResolver resolves tokens through the issuer's userinfo endpoint.
+// It only yields a subject; client_id and scopes stay empty.
+func NewUserinfoResolver(zitadelDomain string) TokenResolver {
+	return &userinfoResolver{zitadelDomain: strings.TrimRight(zitadelDomain, "/")}
+}
+
+func (r *userinfoResolver) Resolve(ctx context.Context, token string) (*TokenInfo, error) {
+	sub, err := fetchUserSub(ctx, r.zitadelDomain, token)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenInfo{Subject: sub}, nil
+}
+
+type introspectionResolver struct {
+	introspector *oidc.Introspector
+}
+
+// NewIntrospectionResolver resolves tokens through RFC 7662 introspection,
+// authenticating as this service with auth.
+func NewIntrospectionResolver(issuer string, auth oidc.ClientAuth) TokenResolver {
+	return &introspectionResolver{introspector: oidc.NewIntrospector(issuer, auth)}
+}
+
+func (r *introspectionResolver) Resolve(ctx context.Context, token string) (*TokenInfo, error) {
+	res, err := r.introspector.Introspect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Active {
+		return nil, ErrInactiveToken
+	}
+	if res.Subject == "" {
+		return nil, fmt.Errorf("%w: sub not present in introspection response", ErrInvalidToken)
+	}
+	return &TokenInfo{
+		Subject:   res.Subject,
+		ClientID:  res.ClientID,
+		Scopes:    strings.Fields(res.Scope),
+		ExpiresAt: res.Expiry.Time(),
+	}, nil
+}
+
+type jwtResolver struct {
+	verifier *oidc.Verifier
+	opaque   TokenResolver
+}
+
+// NewJWTResolver validates JWT access tokens locally with verifier and hands
+// anything that is not a JWT to opaque.
+func NewJWTResolver(verifier *oidc.Verifier, opaque TokenResolver) TokenResolver {
+	return &jwtResolver{verifier: verifier, opaque: opaque}
+}
+
+func (r *jwtResolver) Resolve(ctx context.Context, token string) (*TokenInfo, error) {
+	if !oidc.LooksLikeJWT(token) {
+		if r.opaque == nil {
+			return nil, fmt.Errorf("%w: opaque tokens are not accepted", ErrInvalidToken)
+		}
+		return r.opaque.Resolve(ctx, token)
+	}
+	claims, err := r.verifier.Verify(ctx, token)
+	if errors.Is(err, oidc.ErrKeySetUnavailable) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return &TokenInfo{
+		Subject:   claims.Subject,
+		ClientID:  claims.ClientID,
+		Scopes:    strings.Fields(claims.Scope),
+		ExpiresAt: claims.Expiry.Time(),
+	}, nil
+}
+
+// NewTokenResolverFromEnv builds the resolver chain RoleMiddleware uses:
+// TOKEN_RESOLVER picks userinfo (default) or introspection for opaque
+// tokens, and JWT_VALIDATION=local puts local JWT validation in front.
+func NewTokenResolverFromEnv(zitadelDomain string) (TokenResolver, error) {
+	var opaque TokenResolver
+	switch strings.ToLower(os.Getenv("TOKEN_RESOLVER")) {
+	case "", "userinfo":
+		opaque = NewUserinfoResolver(zitadelDomain)
+	case "introspection":
+		auth, err := introspectionAuthFromEnv(zitadelDomain)
+		if err != nil {
+			return nil, err
+		}
+		opaque = NewIntrospectionResolver(zitadelDomain, auth)
+	default:
+		return nil, fmt.Errorf("unknown TOKEN_RESOLVER %q", os.Getenv("TOKEN_RESOLVER"))
+	}
+
+	if !strings.EqualFold(os.Getenv("JWT_VALIDATION"), "local") {
+		return opaque, nil
+	}
+	issuer := strings.TrimRight(os.Getenv("JWT_ISSUER"), "/")
+	if issuer == "" {
+		issuer = zitadelDomain
+	}
+	audience := splitList(os.Getenv("JWT_AUDIENCE"))
+	if len(audience) == 0 && os.Getenv("PROJECT_ID") != "" {
+		audience = []string{os.Getenv("PROJECT_ID")}
+	}
+	if len(audience) == 0 {
+		return nil, ErrNoAudience
+	}
+	verifier := oidc.NewVerifier(issuer, audience)
+	if types := splitList(os.Getenv("JWT_TYPES")); len(types) > 0 {
+		verifier.WithTypes(types...)
+	}
+	return NewJWTResolver(verifier, opaque), nil
+}
+
+func introspectionAuthFromEnv(zitadelDomain string) (oidc.ClientAuth, error) {
+	if keyFile := os.Getenv("INTROSPECTION_KEY_FILE"); keyFile != "" {
+		key, err := oidc.LoadKeyFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load INTROSPECTION_KEY_FILE: %w", err)
+		}
+		return oidc.PrivateKeyJWTAuth(key, zitadelDomain), nil
+	}
+	clientID := os.Getenv("INTROSPECTION_CLIENT_ID")
+	secret := os.Getenv("INTROSPECTION_CLIENT_SECRET")
+	if clientID == "" || secret == "" {
+		return nil, fmt.Errorf("introspection requires INTROSPECTION_KEY_FILE or INTROSPECTION_CLIENT_ID/INTROSPECTION_CLIENT_SECRET")
+	}
+	return oidc.ClientSecretAuth(clientID, secret), nil
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AbduAllahGabbar/service/pkg/oidc"
+)
+
+// newIntrospectionServer answers introspection for the client svc/secret from
+// responses, keyed by token; unknown tokens are inactive.
+func newIntrospectionServer(t *testing.T, responses map[string]map[string]interface{}) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidc.Discovery{
+			Issuer:                srv.URL,
+			JWKSURI:               srv.URL + "/keys",
+			IntrospectionEndpoint: srv.URL + "/introspect",
+		})
+	})
+	mux.HandleFunc("/introspect", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "svc" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		res, ok := responses[r.FormValue("token")]
+		if !ok {
+			res = map[string]interface{}{"active": false}
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestIntrospectionResolver(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	srv := newIntrospectionServer(t, map[string]map[string]interface{}{
+		"active":  {"active": true, "sub": "u1", "client_id": "app", "scope": "openid profile", "exp": exp},
+		"expired": {"active": false},
+		"no-sub":  {"active": true, "client_id": "app"},
+	})
+
+	tests := []struct {
+		name   string
+		secret string
+		token  string
+		want   *TokenInfo
+		is     error
+		// transient errors are neither ErrInactiveToken nor ErrInvalidToken
+		transient bool
+	}{
+		{name: "active", token: "active", want: &TokenInfo{
+			Subject: "u1", ClientID: "app", Scopes: []string{"openid", "profile"}, ExpiresAt: time.Unix(exp, 0),
+		}},
+		{name: "expired", token: "expired", is: ErrInactiveToken},
+		{name: "unknown token", token: "forged", is: ErrInactiveToken},
+		{name: "no subject", token: "no-sub", is: ErrInvalidToken},
+		{name: "client secret mismatch", secret: "wrong", token: "active", transient: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := tt.secret
+			if secret == "" {
+				secret = "secret"
+			}
+			info, err := NewIntrospectionResolver(srv.URL, oidc.ClientSecretAuth("svc", secret)).Resolve(context.Background(), tt.token)
+			switch {
+			case tt.want != nil:
+				if err != nil {
+					t.Fatalf("Resolve: %v", err)
+				}
+				if !reflect.DeepEqual(info, tt.want) {
+					t.Fatalf("info %+v, want %+v", info, tt.want)
+				}
+			case tt.transient:
+				if err == nil || errors.Is(err, ErrInactiveToken) || errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("error %v, want a transient error", err)
+				}
+			default:
+				if !errors.Is(err, tt.is) {
+					t.Fatalf("error %v, want %v", err, tt.is)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTokenResolverFromEnvAudience(t *testing.T) {
+	tests := []struct {
+		name      string
+		audience  string
+		projectID string
+		wantErr   bool
+	}{
+		{name: "JWT_AUDIENCE", audience: "app-1"},
+		{name: "PROJECT_ID fallback", projectID: "project-1"},
+		{name: "neither", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_VALIDATION", "local")
+			t.Setenv("TOKEN_RESOLVER", "")
+			t.Setenv("JWT_AUDIENCE", tt.audience)
+			t.Setenv("PROJECT_ID", tt.projectID)
+			_, err := NewTokenResolverFromEnv("https://issuer.example.com")
+			if tt.wantErr {
+				if !errors.Is(err, ErrNoAudience) {
+					t.Fatalf("error %v, want ErrNoAudience", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -3,10 +3,9 @@ package middleware
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -16,45 +15,42 @@ import (
 
 const ContextRolesKey = "user_roles"
 const ContextUserIDKey = "user_id"
+const ContextClientIDKey = "token_client_id"
+const ContextScopesKey = "token_scopes"
 
 
 func RoleMiddleware(svc *service.Service) gin.HandlerFunc {
-	zitadelDomain := strings.TrimRight(os.Getenv("ZITADEL_DOMAIN"), "/")
-	if zitadelDomain == "" {
-		log.Println("warning: ZITADEL_DOMAIN is not set (RoleMiddleware will fail for opaque tokens)")
-	}
+	return NewAuthenticatorFromEnv(svc).GinMiddleware()
+}
 
-	return func(c *gin.Context) {
-		userID := strings.TrimSpace(c.GetHeader("X-User-ID"))
+func RoleMiddlewareWithResolver(svc *service.Service, resolver TokenResolver) gin.HandlerFunc {
+	return RoleMiddlewareWithOptions(svc, Options{Resolver: resolver})
+}
 
-		if userID == "" {
-			auth := strings.TrimSpace(c.GetHeader("Authorization"))
-			if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
-				log.Println("RoleMiddleware: missing Authorization bearer or X-User-ID")
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing user id or bearer token"})
-				return
-			}
+func RoleMiddlewareWithOptions(svc *service.Service, opts Options) gin.HandlerFunc {
+	return NewAuthenticator(svc, opts).GinMiddleware()
+}
 
-			tokenStr := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
-			sub, err := fetchUserSub(c.Request.Context(), zitadelDomain, tokenStr)
-			if err != nil || sub == "" {
-				log.Printf("RoleMiddleware: failed to resolve user from token: %v\n", err)
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token", "detail": err.Error()})
+// GinMiddleware stores the caller on the gin context (ContextUserIDKey,
+// ContextRolesKey, ...) and as a Principal on the request context.
+func (a *Authenticator) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := a.AuthenticateRequest(c.Request)
+		if err != nil {
+			var ae *AuthError
+			if errors.As(err, &ae) {
+				c.AbortWithStatusJSON(ae.Status, ae.body())
 				return
 			}
-			userID = sub
-			log.Printf("RoleMiddleware: resolved user id %s from token\n", userID)
-		}
-
-		roles, err := svc.GetUserRoles(c.Request.Context(), userID)
-		if err != nil {
-			log.Printf("RoleMiddleware: GetUserRoles failed for %s: %v\n", userID, err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch roles", "detail": err.Error()})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "authentication failed"})
 			return
 		}
 
-		c.Set(ContextUserIDKey, userID)
-		c.Set(ContextRolesKey, roles)
+		c.Set(ContextUserIDKey, p.UserID)
+		c.Set(ContextRolesKey, p.Roles)
+		c.Set(ContextClientIDKey, p.ClientID)
+		c.Set(ContextScopesKey, p.Scopes)
+		c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), p))
 		c.Next()
 	}
 }
@@ -79,6 +75,11 @@ func fetchUserSub(parentCtx context.Context, zitadelDomain, token string) (strin
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		var body any
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		return "", fmt.Errorf("%w: userinfo returned %d: %v", ErrInvalidToken, res.StatusCode, body)
+	}
 	if res.StatusCode != http.StatusOK {
 		var body any
 		_ = json.NewDecoder(res.Body).Decode(&body)
@@ -91,7 +92,7 @@ func fetchUserSub(parentCtx context.Context, zitadelDomain, token string) (strin
 	}
 	sub, _ := info["sub"].(string)
 	if sub == "" {
-		return "", fmt.Errorf("sub not present in userinfo response")
+		return "", fmt.Errorf("%w: sub not present in userinfo response", ErrInvalidToken)
 	}
 	return sub, nil
 }
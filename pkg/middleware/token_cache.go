@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AbduAllahGabbar/service/pkg/cache"
+)
+
+// TokenStore persists resolved tokens; *service.Service implements it on top
+// of the role cache.
+type TokenStore interface {
+	GetTokenEntry(ctx context.Context, token string) (*cache.TokenEntry, bool, error)
+	SetTokenEntry(ctx context.Context, token string, entry *cache.TokenEntry, ttl time.Duration) error
+}
+
+type cachingResolver struct {
+	next     TokenResolver
+	store    TokenStore
+	maxTTL   time.Duration
+	rejected *rejectedTokens
+}
+
+// NewCachingResolver remembers what next resolved for at most maxTTL (and
+// never past the token's own expiry). Rejected tokens are remembered in
+// process for negativeTTL so replayed garbage does not reach the issuer; at
+// most negativeMax of them are kept, so a flood of random tokens cannot grow
+// memory or Redis.
+func NewCachingResolver(next TokenResolver, store TokenStore, maxTTL, negativeTTL time.Duration, negativeMax int) TokenResolver {
+	r := &cachingResolver{next: next, store: store, maxTTL: maxTTL}
+	if negativeTTL > 0 && negativeMax > 0 {
+		r.rejected = newRejectedTokens(negativeMax, negativeTTL)
+	}
+	return r
+}
+
+func (r *cachingResolver) Resolve(ctx context.Context, token string) (*TokenInfo, error) {
+	if err := r.rejected.get(token); err != nil {
+		return nil, err
+	}
+	// entries without a subject are not usable (older versions stored
+	// rejections there)
+	if e, ok, err := r.store.GetTokenEntry(ctx, token); err == nil && ok && e.Subject != "" {
+		return &TokenInfo{Subject: e.Subject, ClientID: e.ClientID, Scopes: e.Scopes, ExpiresAt: e.ExpiresAt}, nil
+	}
+
+	info, err := r.next.Resolve(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrInactiveToken) || errors.Is(err, ErrInvalidToken) {
+			r.rejected.add(token, err)
+		}
+		return nil, err
+	}
+
+	ttl := r.maxTTL
+	if !info.ExpiresAt.IsZero() {
+		if until := time.Until(info.ExpiresAt); until < ttl {
+			ttl = until
+		}
+	}
+	if ttl > 0 {
+		entry := &cache.TokenEntry{Subject: info.Subject, ClientID: info.ClientID, Scopes: info.Scopes, ExpiresAt: info.ExpiresAt}
+		_ = r.store.SetTokenEntry(ctx, token, entry, ttl)
+	}
+	return info, nil
+}
+
+// rejectedTokens is a size-bounded LRU of rejected tokens, keyed by their
+// SHA-256. A nil *rejectedTokens remembers nothing.
+type rejectedTokens struct {
+	mu    sync.Mutex
+	max   int
+	ttl   time.Duration
+	order *list.List // most recently used first
+	items map[[sha256.Size]byte]*list.Element
+}
+
+type rejectedToken struct {
+	key   [sha256.Size]byte
+	err   error
+	until time.Time
+}
+
+func newRejectedTokens(max int, ttl time.Duration) *rejectedTokens {
+	return &rejectedTokens{max: max, ttl: ttl, order: list.New(), items: make(map[[sha256.Size]byte]*list.Element)}
+}
+
+// get returns the error token was rejected with, or nil when it is not
+// remembered (any more).
+func (t *rejectedTokens) get(token string) error {
+	if t == nil {
+		return nil
+	}
+	key := sha256.Sum256([]byte(token))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	el, ok := t.items[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*rejectedToken)
+	if time.Now().After(e.until) {
+		t.order.Remove(el)
+		delete(t.items, key)
+		return nil
+	}
+	t.order.MoveToFront(el)
+	return e.err
+}
+
+// add remembers token as rejected with err, evicting the least recently used
+// entry when full.
+func (t *rejectedTokens) add(token string, err error) {
+	if t == nil {
+		return
+	}
+	key := sha256.Sum256([]byte(token))
+	until := time.Now().Add(t.ttl)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if el, ok := t.items[key]; ok {
+		e := el.Value.(*rejectedToken)
+		e.err, e.until = err, until
+		t.order.MoveToFront(el)
+		return
+	}
+	if t.order.Len() >= t.max {
+		oldest := t.order.Back()
+		t.order.Remove(oldest)
+		delete(t.items, oldest.Value.(*rejectedToken).key)
+	}
+	t.items[key] = t.order.PushFront(&rejectedToken{key: key, err: err, until: until})
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("warning: invalid %s %q, using %s\n", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+func intFromEnv(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("warning: invalid %s %q, using %d\n", key, v, fallback)
+		return fallback
+	}
+	return n
+}
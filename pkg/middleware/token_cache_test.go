@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AbduAllahGabbar/service/pkg/cache"
+)
+
+type countingResolver struct {
+	calls int
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, token string) (*TokenInfo, error) {
+	r.calls++
+	if token == "good" {
+		return &TokenInfo{Subject: "u1"}, nil
+	}
+	return nil, ErrInactiveToken
+}
+
+type mapTokenStore map[string]*cache.TokenEntry
+
+func (s mapTokenStore) GetTokenEntry(ctx context.Context, token string) (*cache.TokenEntry, bool, error) {
+	e, ok := s[token]
+	return e, ok, nil
+}
+
+func (s mapTokenStore) SetTokenEntry(ctx context.Context, token string, entry *cache.TokenEntry, ttl time.Duration) error {
+	s[token] = entry
+	return nil
+}
+
+func TestCachingResolverKeepsRejectionsBounded(t *testing.T) {
+	next := &countingResolver{}
+	store := mapTokenStore{}
+	r := NewCachingResolver(next, store, time.Minute, time.Minute, 2)
+	ctx := context.Background()
+
+	for _, tok := range []string{"bad1", "bad2", "bad1", "bad3", "good", "good"} {
+		r.Resolve(ctx, tok)
+	}
+	// bad1 was remembered, good came from the store
+	if next.calls != 4 {
+		t.Fatalf("next resolved %d tokens, want 4", next.calls)
+	}
+	if len(store) != 1 || store["good"] == nil {
+		t.Fatalf("store = %v, want only the good token", store)
+	}
+
+	// bad2 was the least recently used and evicted by bad3
+	if _, err := r.Resolve(ctx, "bad1"); !errors.Is(err, ErrInactiveToken) {
+		t.Fatalf("bad1: err = %v", err)
+	}
+	if _, err := r.Resolve(ctx, "bad3"); !errors.Is(err, ErrInactiveToken) {
+		t.Fatalf("bad3: err = %v", err)
+	}
+	if next.calls != 4 {
+		t.Fatalf("remembered rejections reached next (%d calls)", next.calls)
+	}
+	r.Resolve(ctx, "bad2")
+	if next.calls != 5 {
+		t.Fatalf("evicted rejection was not resolved again (%d calls)", next.calls)
+	}
+}
+
+func TestRejectedTokensExpire(t *testing.T) {
+	rt := newRejectedTokens(10, time.Millisecond)
+	rt.add("bad", ErrInactiveToken)
+	time.Sleep(5 * time.Millisecond)
+	if err := rt.get("bad"); err != nil {
+		t.Fatalf("expired rejection still returned: %v", err)
+	}
+	if len(rt.items) != 0 || rt.order.Len() != 0 {
+		t.Fatalf("expired rejection not dropped")
+	}
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"encoding/hex"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTrustedSignature(t *testing.T) {
+	secret := []byte("gateway-secret")
+	now := time.Now().Unix()
+	sign := func(secret []byte, user string, ts int64, method, path string) map[string]string {
+		unix := strconv.FormatInt(ts, 10)
+		return map[string]string{
+			HeaderUserTimestamp: unix,
+			HeaderUserSignature: hex.EncodeToString(SignUserID(secret, user, unix, method, path)),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		maxSkew time.Duration
+		user    string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "valid", maxSkew: time.Minute, user: "u1", headers: sign(secret, "u1", now, "GET", "/v1/roles"), want: true},
+		{name: "skew inside window", maxSkew: time.Minute, user: "u1", headers: sign(secret, "u1", now-50, "GET", "/v1/roles"), want: true},
+		{name: "old timestamp", maxSkew: time.Minute, user: "u1", headers: sign(secret, "u1", now-120, "GET", "/v1/roles")},
+		{name: "future timestamp", maxSkew: time.Minute, user: "u1", headers: sign(secret, "u1", now+120, "GET", "/v1/roles")},
+		{name: "unset skew uses the default", user: "u1", headers: sign(secret, "u1", now-4*60, "GET", "/v1/roles"), want: true},
+		{name: "unset skew still bounded", user: "u1", headers: sign(secret, "u1", now-6*60, "GET", "/v1/roles")},
+		{name: "other secret", maxSkew: time.Minute, user: "u1", headers: sign([]byte("guess"), "u1", now, "GET", "/v1/roles")},
+		{name: "other user", maxSkew: time.Minute, user: "admin", headers: sign(secret, "u1", now, "GET", "/v1/roles")},
+		{name: "other method", maxSkew: time.Minute, user: "u1", headers: sign(secret, "u1", now, "DELETE", "/v1/roles")},
+		{name: "other path", maxSkew: time.Minute, user: "u1", headers: sign(secret, "u1", now, "GET", "/v1/me/profile")},
+		{name: "missing timestamp", maxSkew: time.Minute, user: "u1", headers: map[string]string{
+			HeaderUserSignature: sign(secret, "u1", now, "GET", "/v1/roles")[HeaderUserSignature],
+		}},
+		{name: "signature not hex", maxSkew: time.Minute, user: "u1", headers: map[string]string{
+			HeaderUserTimestamp: strconv.FormatInt(now, 10),
+			HeaderUserSignature: "not-hex",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &TrustPolicy{HMACSecret: secret, MaxSkew: tt.maxSkew}
+			r := httptest.NewRequest("GET", "/v1/roles", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := p.Trusted(r, tt.user); got != tt.want {
+				t.Fatalf("Trusted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedSignatureScopedPath(t *testing.T) {
+	secret := []byte("gateway-secret")
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	r := httptest.NewRequest("GET", "/v1/orgs/o1/roles", nil)
+	r.Header.Set(HeaderUserTimestamp, ts)
+	r.Header.Set(HeaderUserSignature, hex.EncodeToString(SignUserID(secret, "u1", ts, "GET", "/v1/orgs/o1/roles")))
+	if _, ok := stripScope(r, "/v1/orgs/"); !ok {
+		t.Fatal("path not scoped")
+	}
+	p := &TrustPolicy{HMACSecret: secret, MaxSkew: time.Minute}
+	if !p.Trusted(r, "u1") {
+		t.Fatal("signature over the requested path rejected after the path was rewritten")
+	}
+}
+
+func TestTrustPolicyFromEnvSkew(t *testing.T) {
+	tests := []struct {
+		skew    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{skew: "", want: 5 * time.Minute},
+		{skew: "30s", want: 30 * time.Second},
+		{skew: "0", wantErr: true},
+		{skew: "-1m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.skew, func(t *testing.T) {
+			t.Setenv("GATEWAY_HMAC_MAX_SKEW", tt.skew)
+			p, err := TrustPolicyFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("accepted GATEWAY_HMAC_MAX_SKEW=%q", tt.skew)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.MaxSkew != tt.want {
+				t.Fatalf("MaxSkew = %s, want %s", p.MaxSkew, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientAuth authenticates this service towards the issuer's token and
+// introspection endpoints.
+type ClientAuth interface {
+	apply(req *http.Request, form url.Values) error
+}
+
+type clientSecretAuth struct {
+	clientID, secret string
+}
+
+func ClientSecretAuth(clientID, secret string) ClientAuth {
+	return clientSecretAuth{clientID: clientID, secret: secret}
+}
+
+func (a clientSecretAuth) apply(req *http.Request, _ url.Values) error {
+	req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.secret))
+	return nil
+}
+
+type privateKeyJWTAuth struct {
+	key      *Key
+	audience string
+}
+
+// PrivateKeyJWTAuth authenticates with a client assertion signed by key
+// (JWT profile); audience is the issuer URL.
+func PrivateKeyJWTAuth(key *Key, audience string) ClientAuth {
+	return privateKeyJWTAuth{key: key, audience: strings.TrimRight(audience, "/")}
+}
+
+func (a privateKeyJWTAuth) apply(_ *http.Request, form url.Values) error {
+	assertion, err := a.key.SignAssertion(a.audience, time.Hour)
+	if err != nil {
+		return err
+	}
+	form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	form.Set("client_assertion", assertion)
+	return nil
+}
+
+type IntrospectionResponse struct {
+	Active   bool        `json:"active"`
+	Subject  string      `json:"sub"`
+	ClientID string      `json:"client_id"`
+	Scope    string      `json:"scope"`
+	Expiry   NumericDate `json:"exp"`
+	Username string      `json:"username"`
+}
+
+// Introspector calls the issuer's RFC 7662 introspection endpoint, which is
+// looked up from the discovery document on first use.
+type Introspector struct {
+	issuer string
+	auth   ClientAuth
+
+	mu       sync.Mutex
+	endpoint string
+}
+
+func NewIntrospector(issuer string, auth ClientAuth) *Introspector {
+	return &Introspector{issuer: strings.TrimRight(issuer, "/"), auth: auth}
+}
+
+func (i *Introspector) introspectionEndpoint(ctx context.Context) (string, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.endpoint != "" {
+		return i.endpoint, nil
+	}
+	d, err := FetchDiscovery(ctx, i.issuer)
+	if err != nil {
+		return "", err
+	}
+	if d.IntrospectionEndpoint == "" {
+		return "", fmt.Errorf("introspection_endpoint not present in discovery document")
+	}
+	i.endpoint = d.IntrospectionEndpoint
+	return i.endpoint, nil
+}
+
+func (i *Introspector) Introspect(ctx context.Context, token string) (*IntrospectionResponse, error) {
+	endpoint, err := i.introspectionEndpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	form := url.Values{}
+	form.Set("token", token)
+	res, err := postForm(ctx, endpoint, form, i.auth)
+	if err != nil {
+		return nil, fmt.Errorf("introspection request failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var body any
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		return nil, fmt.Errorf("introspection returned %d: %v", res.StatusCode, body)
+	}
+
+	var out IntrospectionResponse
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode introspection response: %w", err)
+	}
+	return &out, nil
+}
+
+func postForm(ctx context.Context, endpoint string, form url.Values, auth ClientAuth) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil {
+		if err := auth.apply(req, form); err != nil {
+			return nil, err
+		}
+	}
+	body := form.Encode()
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return httpClient.Do(req)
+}
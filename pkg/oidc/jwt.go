@@ -0,0 +1,277 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken = errors.New("malformed token")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrTokenNotYet    = errors.New("token not yet valid")
+
+	// ErrKeySetUnavailable means the signing keys could not be fetched; the
+	// token itself may be fine.
+	ErrKeySetUnavailable = errors.New("key set unavailable")
+)
+
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a Audience) Contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+type NumericDate int64
+
+func (d *NumericDate) UnmarshalJSON(b []byte) error {
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	*d = NumericDate(f)
+	return nil
+}
+
+func (d NumericDate) Time() time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(d), 0)
+}
+
+type Claims struct {
+	Issuer    string      `json:"iss"`
+	Subject   string      `json:"sub"`
+	Audience  Audience    `json:"aud"`
+	Expiry    NumericDate `json:"exp"`
+	NotBefore NumericDate `json:"nbf,omitempty"`
+	IssuedAt  NumericDate `json:"iat,omitempty"`
+	ClientID  string      `json:"client_id,omitempty"`
+	Scope     string      `json:"scope,omitempty"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// LooksLikeJWT reports whether token has the three-segment JWS compact form.
+// Anything else is treated as an opaque token.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// DefaultTokenTypes are the typ header values a Verifier accepts unless
+// told otherwise: RFC 9068 access tokens and plain JWTs.
+var DefaultTokenTypes = []string{"at+jwt", "JWT"}
+
+type Verifier struct {
+	issuer   string
+	audience []string
+	types    []string
+	keys     *KeySet
+	leeway   time.Duration
+}
+
+// NewVerifier validates tokens issued by issuer whose aud claim contains one
+// of audience and whose typ header is one of DefaultTokenTypes. A verifier
+// without audience rejects every token.
+func NewVerifier(issuer string, audience []string) *Verifier {
+	issuer = strings.TrimRight(issuer, "/")
+	return &Verifier{
+		issuer:   issuer,
+		audience: audience,
+		types:    DefaultTokenTypes,
+		keys:     NewKeySet(issuer),
+		leeway:   30 * time.Second,
+	}
+}
+
+// WithTypes replaces the accepted typ header values, compared without case
+// and an "application/" prefix (RFC 7515). Tokens without typ are always
+// rejected, so ID tokens issued for the same audience are not taken for
+// access tokens.
+func (v *Verifier) WithTypes(types ...string) *Verifier {
+	v.types = types
+	return v
+}
+
+func (v *Verifier) typeAccepted(typ string) bool {
+	typ = strings.TrimPrefix(strings.ToLower(typ), "application/")
+	for _, t := range v.types {
+		if typ != "" && strings.TrimPrefix(strings.ToLower(t), "application/") == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Verifier) Verify(ctx context.Context, raw string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+	if !v.typeAccepted(h.Typ) {
+		return nil, fmt.Errorf("token type %q not accepted", h.Typ)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformedToken, err)
+	}
+
+	key, err := v.keys.Key(ctx, h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(h.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrMalformedToken, err)
+	}
+
+	if strings.TrimRight(claims.Issuer, "/") != v.issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	matched := false
+	for _, aud := range v.audience {
+		if claims.Audience.Contains(aud) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return nil, fmt.Errorf("token audience %v not accepted", []string(claims.Audience))
+	}
+
+	now := time.Now()
+	if claims.Expiry == 0 {
+		return nil, fmt.Errorf("%w: exp claim missing", ErrMalformedToken)
+	}
+	if now.After(claims.Expiry.Time().Add(v.leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(v.leeway).Before(claims.NotBefore.Time()) {
+		return nil, ErrTokenNotYet
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("sub claim missing")
+	}
+	return &claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func hashFor(alg string) (crypto.Hash, error) {
+	switch alg[len(alg)-3:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported alg %q", alg)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signingInput string, sig []byte) error {
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(pub, []byte(signingInput), sig) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	hash, err := hashFor(alg)
+	if err != nil {
+		return err
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(signingInput))
+	digest := hasher.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match alg %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match alg %s", alg)
+		}
+		if err := rsa.VerifyPSS(pub, hash, digest, sig, nil); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match alg %s", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported alg %q", alg)
+}
@@ -0,0 +1,201 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssuer serves a discovery document and a JWKS holding the RSA key
+// "rsa" and the EC key "ec".
+type testIssuer struct {
+	*httptest.Server
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsa: rk, ec: ek}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Discovery{Issuer: iss.URL, JWKSURI: iss.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {
+			{Kid: "rsa", Kty: "RSA", Use: "sig", N: b64(rk.N.Bytes()), E: b64(big.NewInt(int64(rk.E)).Bytes())},
+			{Kid: "ec", Kty: "EC", Use: "sig", Crv: "P-256", X: b64(ek.X.FillBytes(make([]byte, 32))), Y: b64(ek.Y.FillBytes(make([]byte, 32)))},
+		}})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func (iss *testIssuer) claims(mod func(c map[string]interface{})) map[string]interface{} {
+	now := time.Now()
+	c := map[string]interface{}{
+		"iss": iss.URL,
+		"sub": "user-1",
+		"aud": []string{"project-1", "client-1"},
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	if mod != nil {
+		mod(c)
+	}
+	return c
+}
+
+func (iss *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	return iss.signTyp(t, alg, kid, "JWT", claims)
+}
+
+func (iss *testIssuer) signTyp(t *testing.T, alg, kid, typ string, claims map[string]interface{}) string {
+	t.Helper()
+	hb, _ := json.Marshal(header{Alg: alg, Kid: kid, Typ: typ})
+	cb, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsa, crypto.SHA256, digest[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, iss.ec, digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case "HS256":
+		// the public key as an HMAC secret, as in key confusion attacks
+		mac := hmac.New(sha256.New, iss.rsa.N.Bytes())
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerify(t *testing.T) {
+	iss := newTestIssuer(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := iss.sign(t, "RS256", "rsa", iss.claims(nil))
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name     string
+		audience []string
+		types    []string
+		token    string
+		wantErr  bool
+		is       error
+	}{
+		{name: "valid RS256", token: valid},
+		{name: "valid ES256", token: iss.sign(t, "ES256", "ec", iss.claims(nil))},
+		{name: "second audience", audience: []string{"client-1"}, token: valid},
+		{name: "expired within leeway", token: iss.sign(t, "RS256", "rsa", iss.claims(func(c map[string]interface{}) {
+			c["exp"] = time.Now().Add(-10 * time.Second).Unix()
+		}))},
+		{name: "alg none", token: b64JSON(header{Alg: "none", Kid: "rsa"}) + "." + parts[1] + ".", wantErr: true},
+		{name: "alg HS256 with the public key", token: iss.sign(t, "HS256", "rsa", iss.claims(nil)), wantErr: true},
+		{name: "alg does not match key", token: b64JSON(header{Alg: "ES256", Kid: "rsa"}) + "." + parts[1] + "." + parts[2], wantErr: true},
+		{name: "expired", token: iss.sign(t, "RS256", "rsa", iss.claims(func(c map[string]interface{}) {
+			c["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+		})), is: ErrTokenExpired},
+		{name: "not yet valid", token: iss.sign(t, "RS256", "rsa", iss.claims(func(c map[string]interface{}) {
+			c["nbf"] = time.Now().Add(2 * time.Minute).Unix()
+		})), is: ErrTokenNotYet},
+		{name: "missing exp", token: iss.sign(t, "RS256", "rsa", iss.claims(func(c map[string]interface{}) {
+			delete(c, "exp")
+		})), is: ErrMalformedToken},
+		{name: "wrong audience", token: iss.sign(t, "RS256", "rsa", iss.claims(func(c map[string]interface{}) {
+			c["aud"] = "other-project"
+		})), wantErr: true},
+		{name: "no audience configured", audience: []string{}, token: valid, wantErr: true},
+		{name: "wrong issuer", token: iss.sign(t, "RS256", "rsa", iss.claims(func(c map[string]interface{}) {
+			c["iss"] = "https://evil.example.com"
+		})), wantErr: true},
+		{name: "tampered claims", token: parts[0] + "." + b64JSON(iss.claims(func(c map[string]interface{}) {
+			c["sub"] = "admin"
+		})) + "." + parts[2], wantErr: true},
+		{name: "signed by another key", token: func() string {
+			saved := iss.rsa
+			iss.rsa = other
+			defer func() { iss.rsa = saved }()
+			return iss.sign(t, "RS256", "rsa", iss.claims(nil))
+		}(), wantErr: true},
+		{name: "unknown kid", token: iss.sign(t, "RS256", "missing", iss.claims(nil)), wantErr: true},
+		{name: "two segments", token: parts[0] + "." + parts[1], is: ErrMalformedToken},
+		{name: "typ at+jwt", token: iss.signTyp(t, "RS256", "rsa", "at+jwt", iss.claims(nil))},
+		{name: "typ with media type prefix", token: iss.signTyp(t, "RS256", "rsa", "application/at+JWT", iss.claims(nil))},
+		{name: "typ missing", token: iss.signTyp(t, "RS256", "rsa", "", iss.claims(nil)), wantErr: true},
+		{name: "typ of another token kind", token: iss.signTyp(t, "RS256", "rsa", "logout+jwt", iss.claims(nil)), wantErr: true},
+		{name: "typ not configured", types: []string{"at+jwt"}, token: valid, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audience := tt.audience
+			if audience == nil {
+				audience = []string{"project-1"}
+			}
+			v := NewVerifier(iss.URL, audience)
+			if tt.types != nil {
+				v.WithTypes(tt.types...)
+			}
+			claims, err := v.Verify(context.Background(), tt.token)
+			wantErr := tt.wantErr || tt.is != nil
+			if wantErr {
+				if err == nil {
+					t.Fatalf("accepted token, claims %+v", claims)
+				}
+				if tt.is != nil && !errors.Is(err, tt.is) {
+					t.Fatalf("error %v, want %v", err, tt.is)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rejected valid token: %v", err)
+			}
+			if claims.Subject != "user-1" {
+				t.Fatalf("subject %q, want user-1", claims.Subject)
+			}
+		})
+	}
+}
+
+func b64JSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
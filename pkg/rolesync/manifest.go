@@ -0,0 +1,86 @@
+package rolesync
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is the desired set of project roles and, optionally, role
+// assignments. JSON manifests are accepted too.
+type Manifest struct {
+	Roles       []RoleSpec       `yaml:"roles" json:"roles"`
+	Assignments []AssignmentSpec `yaml:"assignments,omitempty" json:"assignments,omitempty"`
+}
+
+type RoleSpec struct {
+	Key         string `yaml:"key" json:"key"`
+	DisplayName string `yaml:"display_name" json:"display_name"`
+	Group       string `yaml:"group,omitempty" json:"group,omitempty"`
+}
+
+// AssignmentSpec lists roles a user must hold. Assignments are additive:
+// roles the user holds beyond these are left alone.
+type AssignmentSpec struct {
+	UserID string   `yaml:"user_id" json:"user_id"`
+	Roles  []string `yaml:"roles" json:"roles"`
+}
+
+func LoadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseManifest(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func ParseManifest(b []byte) (*Manifest, error) {
+	var m Manifest
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *Manifest) validate() error {
+	keys := make(map[string]struct{}, len(m.Roles))
+	for i := range m.Roles {
+		r := &m.Roles[i]
+		r.Key = strings.TrimSpace(r.Key)
+		if r.Key == "" {
+			return fmt.Errorf("roles[%d]: key is required", i)
+		}
+		if _, dup := keys[r.Key]; dup {
+			return fmt.Errorf("roles[%d]: duplicate key %q", i, r.Key)
+		}
+		keys[r.Key] = struct{}{}
+		if r.DisplayName == "" {
+			r.DisplayName = r.Key
+		}
+	}
+	users := make(map[string]struct{}, len(m.Assignments))
+	for i, a := range m.Assignments {
+		if strings.TrimSpace(a.UserID) == "" {
+			return fmt.Errorf("assignments[%d]: user_id is required", i)
+		}
+		if _, dup := users[a.UserID]; dup {
+			return fmt.Errorf("assignments[%d]: duplicate user %q", i, a.UserID)
+		}
+		users[a.UserID] = struct{}{}
+		for _, role := range a.Roles {
+			if _, ok := keys[role]; !ok {
+				return fmt.Errorf("assignments[%d]: role %q is not declared in roles", i, role)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package rolesync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/AbduAllahGabbar/service/pkg/zitadel"
+)
+
+// Source reports the current state of the project; zitadel.Client
+// satisfies it.
+type Source interface {
+	ListRoles(ctx context.Context, q zitadel.RoleQuery) (*zitadel.RoleList, error)
+	GetUserRoles(ctx context.Context, userID string) ([]string, error)
+}
+
+// Target applies a plan. zitadel.Client satisfies it; service.Service
+// provides one that also keeps caches and the audit log up to date.
+type Target interface {
+	CreateRoles(ctx context.Context, roles []zitadel.RoleInput) ([]string, error)
+	UpdateRole(ctx context.Context, key, displayName, group string) error
+	DeleteRole(ctx context.Context, roleID string) error
+	AssignRolesToUser(ctx context.Context, userID string, roleIDs []string) error
+}
+
+var (
+	_ Source = zitadel.Client(nil)
+	_ Target = zitadel.Client(nil)
+)
+
+type Options struct {
+	// Prune deletes project roles that are not in the manifest.
+	Prune bool
+}
+
+type RoleChange struct {
+	Key  string       `json:"key"`
+	From zitadel.Role `json:"from"`
+	To   RoleSpec     `json:"to"`
+}
+
+type Assignment struct {
+	UserID string   `json:"user_id"`
+	Roles  []string `json:"roles"`
+}
+
+// Plan is the diff between a manifest and the project. Changed roles differ
+// only in display name or group; Unmanaged roles are kept unless pruned.
+type Plan struct {
+	Create    []RoleSpec   `json:"create"`
+	Delete    []string     `json:"delete"`
+	Changed   []RoleChange `json:"changed"`
+	Assign    []Assignment `json:"assign"`
+	Unmanaged []string     `json:"unmanaged"`
+}
+
+func (p *Plan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Delete) == 0 && len(p.Changed) == 0 && len(p.Assign) == 0
+}
+
+// String renders the plan as a diff: "+" creates, "-" deletes, "~" updates.
+func (p *Plan) String() string {
+	var b strings.Builder
+	for _, r := range p.Create {
+		fmt.Fprintf(&b, "+ role %s (display_name=%q group=%q)\n", r.Key, r.DisplayName, r.Group)
+	}
+	for _, c := range p.Changed {
+		if c.From.DisplayName != c.To.DisplayName {
+			fmt.Fprintf(&b, "~ role %s display_name %q -> %q\n", c.Key, c.From.DisplayName, c.To.DisplayName)
+		}
+		if c.To.Group != "" && c.From.Group != c.To.Group {
+			fmt.Fprintf(&b, "~ role %s group %q -> %q\n", c.Key, c.From.Group, c.To.Group)
+		}
+	}
+	for _, a := range p.Assign {
+		fmt.Fprintf(&b, "+ assign %s -> %s\n", strings.Join(a.Roles, ","), a.UserID)
+	}
+	for _, k := range p.Delete {
+		fmt.Fprintf(&b, "- role %s\n", k)
+	}
+	for _, k := range p.Unmanaged {
+		fmt.Fprintf(&b, "  role %s is not in the manifest (use prune to delete)\n", k)
+	}
+	if b.Len() == 0 {
+		return "no changes\n"
+	}
+	return b.String()
+}
+
+// BuildPlan diffs m against what src reports for the project.
+func BuildPlan(ctx context.Context, src Source, m *Manifest, opts Options) (*Plan, error) {
+	list, err := src.ListRoles(ctx, zitadel.RoleQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("list roles: %w", err)
+	}
+	current := list.Roles
+	existing := make(map[string]zitadel.Role, len(current))
+	for _, r := range current {
+		existing[r.Key] = r
+	}
+
+	p := &Plan{Create: []RoleSpec{}, Delete: []string{}, Changed: []RoleChange{}, Assign: []Assignment{}, Unmanaged: []string{}}
+	wanted := make(map[string]struct{}, len(m.Roles))
+	for _, spec := range m.Roles {
+		wanted[spec.Key] = struct{}{}
+		cur, ok := existing[spec.Key]
+		if !ok {
+			p.Create = append(p.Create, spec)
+			continue
+		}
+		if cur.DisplayName != spec.DisplayName || (spec.Group != "" && cur.Group != spec.Group) {
+			p.Changed = append(p.Changed, RoleChange{Key: spec.Key, From: cur, To: spec})
+		}
+	}
+	for _, r := range current {
+		if _, ok := wanted[r.Key]; ok {
+			continue
+		}
+		if opts.Prune {
+			p.Delete = append(p.Delete, r.Key)
+		} else {
+			p.Unmanaged = append(p.Unmanaged, r.Key)
+		}
+	}
+	sort.Strings(p.Delete)
+	sort.Strings(p.Unmanaged)
+
+	for _, a := range m.Assignments {
+		has, err := src.GetUserRoles(ctx, a.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("get roles of %s: %w", a.UserID, err)
+		}
+		held := make(map[string]struct{}, len(has))
+		for _, r := range has {
+			held[r] = struct{}{}
+		}
+		var missing []string
+		for _, r := range a.Roles {
+			if _, ok := held[r]; !ok {
+				missing = append(missing, r)
+			}
+		}
+		if len(missing) > 0 {
+			p.Assign = append(p.Assign, Assignment{UserID: a.UserID, Roles: missing})
+		}
+	}
+	return p, nil
+}
+
+type StepResult struct {
+	Op     string `json:"op"`
+	Role   string `json:"role,omitempty"`
+	UserID string `json:"user_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Result struct {
+	Steps  []StepResult `json:"steps"`
+	Failed int          `json:"failed"`
+}
+
+// Apply creates roles, updates changed ones, then assigns, then deletes.
+// Every step is attempted; an error is returned if any of them failed.
+func Apply(ctx context.Context, dst Target, p *Plan) (*Result, error) {
+	res := &Result{Steps: []StepResult{}}
+	step := func(op, role, user string, err error) {
+		s := StepResult{Op: op, Role: role, UserID: user}
+		if err != nil {
+			s.Error = err.Error()
+			res.Failed++
+		}
+		res.Steps = append(res.Steps, s)
+	}
+
+	if len(p.Create) > 0 {
+		in := make([]zitadel.RoleInput, 0, len(p.Create))
+		for _, r := range p.Create {
+			in = append(in, zitadel.RoleInput{Name: r.Key, Desc: r.DisplayName, Group: r.Group})
+		}
+		_, err := dst.CreateRoles(ctx, in)
+		for _, r := range p.Create {
+			step("create", r.Key, "", err)
+		}
+	}
+	for _, c := range p.Changed {
+		group := c.To.Group
+		if group == "" {
+			group = c.From.Group
+		}
+		step("update", c.Key, "", dst.UpdateRole(ctx, c.Key, c.To.DisplayName, group))
+	}
+	for _, a := range p.Assign {
+		err := dst.AssignRolesToUser(ctx, a.UserID, a.Roles)
+		for _, r := range a.Roles {
+			step("assign", r, a.UserID, err)
+		}
+	}
+	for _, k := range p.Delete {
+		step("delete", k, "", dst.DeleteRole(ctx, k))
+	}
+
+	if res.Failed > 0 {
+		return res, fmt.Errorf("%d of %d sync steps failed", res.Failed, len(res.Steps))
+	}
+	return res, nil
+}
@@ -0,0 +1,32 @@
+// Package scope carries the Zitadel organization and project a request acts
+// in through its context, for the packages that key their work by them
+// without talking to Zitadel themselves.
+package scope
+
+import "context"
+
+type orgIDKey struct{}
+
+// WithOrgID makes the Zitadel calls made with ctx run in organization orgID
+// instead of the client's default org.
+func WithOrgID(ctx context.Context, orgID string) context.Context {
+	return context.WithValue(ctx, orgIDKey{}, orgID)
+}
+
+func OrgIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(orgIDKey{}).(string)
+	return id
+}
+
+type projectIDKey struct{}
+
+// WithProjectID makes the calls made with ctx manage the roles, grants and
+// caches of project projectID instead of the default project.
+func WithProjectID(ctx context.Context, projectID string) context.Context {
+	return context.WithValue(ctx, projectIDKey{}, projectID)
+}
+
+func ProjectIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(projectIDKey{}).(string)
+	return id
+}
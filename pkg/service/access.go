@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AbduAllahGabbar/service/pkg/cache"
+	"github.com/AbduAllahGabbar/service/pkg/scope"
+)
+
+var (
+	ErrInvalidAccessRequest  = errors.New("invalid access request")
+	ErrAccessRequestNotFound = cache.ErrAccessRequestNotFound
+	ErrDuplicateRequest      = cache.ErrDuplicateAccessRequest
+	ErrRequestNotPending     = errors.New("access request is not pending")
+	ErrNotApprover           = errors.New("caller may not decide this access request")
+	ErrSelfApproval          = errors.New("requesters cannot decide their own access request")
+)
+
+// WithAccessApprovers configures who decides access requests: approvers maps
+// a requested role to the roles allowed to approve it, with "*" covering
+// roles that have no entry of their own.
+func WithAccessApprovers(approvers map[string][]string) Option {
+	return func(s *Service) { s.approvers = approvers }
+}
+
+// ApproversFor returns the roles allowed to decide requests for role.
+func (s *Service) ApproversFor(role string) []string {
+	if a, ok := s.approvers[role]; ok {
+		return a
+	}
+	return s.approvers["*"]
+}
+
+// CanApprove reports whether a caller holding roles may decide requests for
+// role.
+func (s *Service) CanApprove(roles []string, role string) bool {
+	return containsAny(roles, s.ApproversFor(role))
+}
+
+// IsAccessApprover reports whether roles include an approver role for any
+// requestable role.
+func (s *Service) IsAccessApprover(roles []string) bool {
+	for _, approvers := range s.approvers {
+		if containsAny(roles, approvers) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) SubmitAccessRequest(ctx context.Context, userID, role, justification, duration string) (*cache.AccessRequest, error) {
+	role = strings.TrimSpace(role)
+	justification = strings.TrimSpace(justification)
+	if userID == "" || role == "" {
+		return nil, fmt.Errorf("%w: role is required", ErrInvalidAccessRequest)
+	}
+	if justification == "" {
+		return nil, fmt.Errorf("%w: justification is required", ErrInvalidAccessRequest)
+	}
+	if duration != "" {
+		d, err := time.ParseDuration(duration)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%w: duration must be a positive duration such as \"8h\"", ErrInvalidAccessRequest)
+		}
+	}
+	now := time.Now().UTC()
+	r := &cache.AccessRequest{
+		UserID:        userID,
+		Role:          role,
+		Justification: justification,
+		Duration:      duration,
+		OrgID:         scope.OrgIDFrom(ctx),
+		ProjectID:     scope.ProjectIDFrom(ctx),
+		Status:        "pending",
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		History:       []cache.AccessRequestEvent{{Time: now, Actor: userID, Action: "submitted", Comment: justification}},
+	}
+	if err := s.cache.CreateAccessRequest(ctx, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *Service) GetAccessRequest(ctx context.Context, id string) (*cache.AccessRequest, error) {
+	return s.cache.GetAccessRequest(ctx, id)
+}
+
+func (s *Service) ListAccessRequests(ctx context.Context, f cache.AccessRequestFilter) ([]*cache.AccessRequest, error) {
+	return s.cache.ListAccessRequests(ctx, f)
+}
+
+// ListVisibleAccessRequests is ListAccessRequests restricted to the requests
+// the caller may see: their own and those for roles they may approve.
+func (s *Service) ListVisibleAccessRequests(ctx context.Context, callerID string, callerRoles []string, f cache.AccessRequestFilter) ([]*cache.AccessRequest, error) {
+	f.Match = func(r *cache.AccessRequest) bool {
+		return r.UserID == callerID || s.CanApprove(callerRoles, r.Role)
+	}
+	return s.cache.ListAccessRequests(ctx, f)
+}
+
+// ApproveAccessRequest approves a pending request and grants the role through
+// AssignRole. The grant expires at expiresAt when given, otherwise after the
+// requested duration, if any. If the grant fails the request is put back to
+// pending and the failure is recorded in its history.
+func (s *Service) ApproveAccessRequest(ctx context.Context, id, approverID string, approverRoles []string, comment string, expiresAt *time.Time) (*cache.AccessRequest, error) {
+	now := time.Now().UTC()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidAccessRequest)
+	}
+	r, err := s.cache.UpdateAccessRequest(ctx, id, func(r *cache.AccessRequest) error {
+		if err := s.checkDecision(r, approverID, approverRoles); err != nil {
+			return err
+		}
+		exp := expiresAt
+		if exp == nil && r.Duration != "" {
+			d, _ := time.ParseDuration(r.Duration)
+			t := now.Add(d)
+			exp = &t
+		}
+		if exp != nil {
+			t := exp.UTC()
+			exp = &t
+		}
+		r.Status = "approved"
+		r.DecidedBy = approverID
+		r.ExpiresAt = exp
+		r.UpdatedAt = now
+		r.History = append(r.History, cache.AccessRequestEvent{Time: now, Actor: approverID, Action: "approved", Comment: comment})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []AssignOption
+	if r.ExpiresAt != nil {
+		opts = append(opts, WithExpiry(*r.ExpiresAt))
+	}
+	if r.OrgID != "" {
+		ctx = scope.WithOrgID(ctx, r.OrgID)
+	}
+	if r.ProjectID != "" {
+		ctx = scope.WithProjectID(ctx, r.ProjectID)
+	}
+	if err := s.AssignRole(ctx, r.Role, r.UserID, opts...); err != nil {
+		grantErr := err
+		_, err := s.cache.UpdateAccessRequest(ctx, id, func(r *cache.AccessRequest) error {
+			r.Status = "pending"
+			r.DecidedBy = ""
+			r.ExpiresAt = nil
+			r.UpdatedAt = time.Now().UTC()
+			r.History = append(r.History, cache.AccessRequestEvent{Time: r.UpdatedAt, Actor: approverID, Action: "grant_failed", Comment: grantErr.Error()})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("assign role: %v (and reverting request failed: %w)", grantErr, err)
+		}
+		return nil, fmt.Errorf("assign role: %w", grantErr)
+	}
+	return r, nil
+}
+
+func (s *Service) RejectAccessRequest(ctx context.Context, id, approverID string, approverRoles []string, comment string) (*cache.AccessRequest, error) {
+	return s.cache.UpdateAccessRequest(ctx, id, func(r *cache.AccessRequest) error {
+		if err := s.checkDecision(r, approverID, approverRoles); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		r.Status = "rejected"
+		r.DecidedBy = approverID
+		r.UpdatedAt = now
+		r.History = append(r.History, cache.AccessRequestEvent{Time: now, Actor: approverID, Action: "rejected", Comment: comment})
+		return nil
+	})
+}
+
+// CancelAccessRequest withdraws a pending request; only its requester may do
+// so.
+func (s *Service) CancelAccessRequest(ctx context.Context, id, userID string) (*cache.AccessRequest, error) {
+	return s.cache.UpdateAccessRequest(ctx, id, func(r *cache.AccessRequest) error {
+		if r.UserID != userID {
+			return ErrAccessRequestNotFound
+		}
+		if r.Status != "pending" {
+			return ErrRequestNotPending
+		}
+		now := time.Now().UTC()
+		r.Status = "cancelled"
+		r.UpdatedAt = now
+		r.History = append(r.History, cache.AccessRequestEvent{Time: now, Actor: userID, Action: "cancelled"})
+		return nil
+	})
+}
+
+func (s *Service) checkDecision(r *cache.AccessRequest, approverID string, approverRoles []string) error {
+	if r.Status != "pending" {
+		return ErrRequestNotPending
+	}
+	if r.UserID == approverID {
+		return ErrSelfApproval
+	}
+	if !s.CanApprove(approverRoles, r.Role) {
+		return ErrNotApprover
+	}
+	return nil
+}
+
+func containsAny(have, want []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"github.com/AbduAllahGabbar/service/pkg/audit"
+	"github.com/AbduAllahGabbar/service/pkg/scope"
+)
+
+// WithAudit sends an event for every role mutation to sink.
+func WithAudit(sink audit.Sink) Option {
+	return func(s *Service) { s.auditLog = sink }
+}
+
+func (s *Service) QueryAudit(ctx context.Context, f audit.Filter) (*audit.Page, error) {
+	return s.auditLog.Query(ctx, f)
+}
+
+// record writes an audit event for a mutation that finished with err. Sink
+// failures are logged and never fail the mutation itself. Mutations made in
+// a non-default organization or project carry its ID as detail org_id or
+// project_id.
+func (s *Service) record(ctx context.Context, action, role, userID string, err error, details map[string]string) {
+	evt := audit.NewEvent(ctx, action, role, userID, err)
+	evt.Details = details
+	org, project := scope.OrgIDFrom(ctx), scope.ProjectIDFrom(ctx)
+	if org != "" || project != "" {
+		evt.Details = map[string]string{}
+		for k, v := range details {
+			evt.Details[k] = v
+		}
+		if org != "" {
+			evt.Details["org_id"] = org
+		}
+		if project != "" {
+			evt.Details["project_id"] = project
+		}
+	}
+	if werr := s.auditLog.Write(ctx, evt); werr != nil {
+		log.Printf("audit: write %s failed: %v", action, werr)
+	}
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/AbduAllahGabbar/service/pkg/zitadel"
+)
+
+const (
+	ItemCreated    = zitadel.RoleCreated
+	ItemExisted    = zitadel.RoleExisted
+	ItemFailed     = zitadel.RoleFailed
+	ItemAssigned   = "assigned"
+	ItemRolledBack = "rolled_back"
+)
+
+// BatchItem is the outcome for one role of a batch. Code is a stable error
+// code (see errorCode) when Status is failed, or rollback_failed when an
+// atomic batch could not undo this item.
+type BatchItem struct {
+	Key     string `json:"key"`
+	Status  string `json:"status"`
+	Updated bool   `json:"updated,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type BatchResult struct {
+	OK         bool        `json:"ok"`
+	Items      []BatchItem `json:"items"`
+	Succeeded  int         `json:"succeeded"`
+	Failed     int         `json:"failed"`
+	RolledBack bool        `json:"rolled_back,omitempty"`
+}
+
+func (r *BatchResult) count() {
+	r.Succeeded, r.Failed = 0, 0
+	for _, it := range r.Items {
+		switch it.Status {
+		case ItemFailed:
+			r.Failed++
+		case ItemRolledBack:
+		default:
+			r.Succeeded++
+		}
+	}
+	r.OK = r.Failed == 0
+}
+
+// RoleBatchOptions controls CreateRolesBatch. Without Idempotent, roles that
+// already exist are failures; UpdateDisplayName implies Idempotent. Atomic
+// undoes the creations and renames of the batch if any item failed.
+type RoleBatchOptions struct {
+	Idempotent        bool
+	UpdateDisplayName bool
+	Atomic            bool
+}
+
+// CreateRolesBatch creates roles one by one and reports each outcome. The
+// error is only set when the batch could not be processed at all.
+func (s *Service) CreateRolesBatch(ctx context.Context, roles []zitadel.RoleInput, opts RoleBatchOptions) (*BatchResult, error) {
+	results, err := s.EnsureRoles(ctx, roles, opts.UpdateDisplayName)
+	if err != nil {
+		return nil, err
+	}
+	idempotent := opts.Idempotent || opts.UpdateDisplayName
+
+	res := &BatchResult{Items: make([]BatchItem, 0, len(results))}
+	for _, r := range results {
+		it := BatchItem{Key: r.Key, Status: r.Status, Updated: r.Updated}
+		switch {
+		case r.Status == zitadel.RoleFailed:
+			it.Code, it.Error = errorCode(r.Err), r.Error
+		case r.Status == zitadel.RoleExisted && !idempotent:
+			it.Status, it.Code, it.Error = ItemFailed, "already_exists", "role already exists"
+		}
+		res.Items = append(res.Items, it)
+	}
+	res.count()
+	if !opts.Atomic || res.Failed == 0 {
+		return res, nil
+	}
+
+	rollback := map[string]string{"reason": "rollback"}
+	for i := range res.Items {
+		it := &res.Items[i]
+		var err error
+		switch {
+		case it.Status == ItemCreated:
+			err = s.zitadel.DeleteRole(ctx, it.Key)
+			s.record(ctx, "role.delete", it.Key, "", err, rollback)
+		case it.Updated:
+			prev := results[i].Previous
+			_, err = s.updateRole(ctx, it.Key, RoleUpdate{DisplayName: &prev})
+			s.record(ctx, "role.update", it.Key, "", err, map[string]string{"reason": "rollback", "display_name": prev})
+		default:
+			continue
+		}
+		if err != nil {
+			it.Code, it.Error = "rollback_failed", err.Error()
+			continue
+		}
+		it.Status, it.Updated = ItemRolledBack, false
+	}
+	res.RolledBack = true
+	res.count()
+	return res, nil
+}
+
+// AssignRolesBatch grants each role to the user separately and reports each
+// outcome; roles the user already holds are reported as existed and keep
+// their grant as it is, so an expiry only applies to the roles this batch
+// granted. With atomic, the roles granted by this batch are revoked again if
+// any item failed, and no expiry is scheduled.
+func (s *Service) AssignRolesBatch(ctx context.Context, userID string, roleIDs []string, atomic bool, opts ...AssignOption) (*BatchResult, error) {
+	held, err := s.zitadel.GetUserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	has := make(map[string]bool, len(held))
+	for _, r := range held {
+		has[r] = true
+	}
+	o := buildAssignOptions(opts)
+
+	res := &BatchResult{Items: make([]BatchItem, 0, len(roleIDs))}
+	errs := make([]error, len(roleIDs))
+	for i, roleID := range roleIDs {
+		it := BatchItem{Key: roleID, Status: ItemExisted}
+		if !has[roleID] {
+			if err := s.zitadel.AssignRoleToUser(ctx, roleID, userID); err != nil {
+				it.Status, it.Code, it.Error = ItemFailed, errorCode(err), err.Error()
+				errs[i] = err
+			} else {
+				it.Status = ItemAssigned
+				has[roleID] = true
+			}
+		}
+		res.Items = append(res.Items, it)
+	}
+	res.count()
+	recordAssigns := func() {
+		for i, it := range res.Items {
+			s.record(ctx, "role.assign", it.Key, userID, errs[i], o.details())
+		}
+	}
+
+	if atomic && res.Failed > 0 {
+		recordAssigns()
+		for i := range res.Items {
+			it := &res.Items[i]
+			if it.Status != ItemAssigned {
+				continue
+			}
+			err := s.zitadel.RemoveRoleFromUser(ctx, it.Key, userID)
+			s.record(ctx, "role.remove", it.Key, userID, err, map[string]string{"reason": "rollback"})
+			if err != nil {
+				it.Code, it.Error = "rollback_failed", err.Error()
+				continue
+			}
+			it.Status = ItemRolledBack
+		}
+		res.RolledBack = true
+		res.count()
+	} else {
+		for i := range res.Items {
+			it := &res.Items[i]
+			if it.Status == ItemFailed || it.Status == ItemExisted && !o.expiresAt.IsZero() {
+				continue
+			}
+			if err := s.scheduleExpiry(ctx, it.Key, userID, o); err != nil {
+				it.Status, it.Code, it.Error = ItemFailed, "internal", err.Error()
+				errs[i] = err
+			}
+		}
+		res.count()
+		recordAssigns()
+	}
+
+	if err := s.cache.InvalidateRoles(ctx, userID); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// errorCode maps an error from Zitadel or the client to a stable code for
+// batch results.
+func errorCode(err error) string {
+	var he *zitadel.HTTPError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &he):
+		switch {
+		case he.StatusCode == http.StatusBadRequest:
+			return "invalid_argument"
+		case he.StatusCode == http.StatusUnauthorized, he.StatusCode == http.StatusForbidden:
+			return "permission_denied"
+		case he.StatusCode == http.StatusNotFound:
+			return "not_found"
+		case he.StatusCode == http.StatusConflict:
+			return "already_exists"
+		case he.StatusCode == http.StatusTooManyRequests:
+			return "rate_limited"
+		}
+		return "upstream_error"
+	case breakerRejected(err):
+		return "circuit_open"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "upstream_error"
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAssignRolesBatchExpiryLeavesHeldRoles(t *testing.T) {
+	svc, z, c := newTestService()
+	z.grant("u1", "admin")
+
+	res, err := svc.AssignRolesBatch(context.Background(), "u1", []string{"admin", "oncall"}, false, WithExpiry(time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.OK {
+		t.Fatalf("batch failed: %+v", res)
+	}
+	want := map[string]string{"admin": ItemExisted, "oncall": ItemAssigned}
+	for _, it := range res.Items {
+		if it.Status != want[it.Key] {
+			t.Errorf("%s: status %q, want %q", it.Key, it.Status, want[it.Key])
+		}
+	}
+	if c.scheduled("admin", "u1") {
+		t.Error("expiry scheduled for a role the user already held")
+	}
+	if !c.scheduled("oncall", "u1") {
+		t.Error("no expiry scheduled for the role granted by the batch")
+	}
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/sony/gobreaker"
+
+	"github.com/AbduAllahGabbar/service/pkg/cache"
+	"github.com/AbduAllahGabbar/service/pkg/scope"
+)
+
+const (
+	defaultBulkConcurrency = 8
+	// bulkBreakerWait is how long a user's assignment keeps retrying while
+	// the circuit breaker rejects calls before the rest of the batch is
+	// given up.
+	bulkBreakerWait   = time.Minute
+	bulkProgressEvery = 25
+)
+
+// WithBulkConcurrency bounds the parallel Zitadel calls of
+// AssignRoleToUsers.
+func WithBulkConcurrency(n int) Option {
+	return func(s *Service) {
+		if n > 0 {
+			s.bulkConcurrency = n
+		}
+	}
+}
+
+// AssignRoleToUsers grants roleID to every user, at most WithBulkConcurrency
+// at a time, and returns one result per distinct user in input order. While
+// the circuit breaker is open, calls back off for up to a minute; if it stays
+// open, the users not attempted yet are failed with code circuit_open. Users
+// who already hold the role are reported as existed and keep their grant.
+func (s *Service) AssignRoleToUsers(ctx context.Context, roleID string, userIDs []string, opts ...AssignOption) []cache.BulkAssignResult {
+	return s.assignRoleToUsers(ctx, roleID, uniqueStrings(userIDs), buildAssignOptions(opts), nil)
+}
+
+// StartAssignRoleToUsers runs AssignRoleToUsers in the background and
+// returns the ID of a job whose progress and results are served by
+// GetBulkAssignJob.
+func (s *Service) StartAssignRoleToUsers(ctx context.Context, roleID string, userIDs []string, opts ...AssignOption) (string, error) {
+	users := uniqueStrings(userIDs)
+	job := &cache.BulkAssignJob{
+		JobID:     fmt.Sprintf("%d", time.Now().UnixNano()),
+		Type:      "bulk_assign",
+		Role:      roleID,
+		ProjectID: scope.ProjectIDFrom(ctx),
+		Status:    "running",
+		Total:     len(users),
+		StartedAt: time.Now(),
+	}
+	pending := make(map[int]cache.BulkAssignResult, len(users))
+	for i, u := range users {
+		pending[i] = cache.BulkAssignResult{UserID: u, Status: "pending"}
+	}
+	if err := s.cache.SaveBulkAssignJob(ctx, job, pending); err != nil {
+		return "", err
+	}
+
+	// keep the caller's actor and request ID for the audit log, but not its
+	// deadline
+	bg := context.WithoutCancel(ctx)
+	o := buildAssignOptions(opts)
+	go func() {
+		// results are saved in batches with the counters; only the ones
+		// finished since the last save are written
+		unsaved := make(map[int]cache.BulkAssignResult, bulkProgressEvery)
+		save := func() {
+			if err := s.cache.SaveBulkAssignJob(bg, job, unsaved); err != nil {
+				log.Printf("bulk assign job %s: save failed: %v", job.JobID, err)
+				return
+			}
+			unsaved = make(map[int]cache.BulkAssignResult, bulkProgressEvery)
+		}
+		circuitOpen := false
+		s.assignRoleToUsers(bg, roleID, users, o, func(i int, r cache.BulkAssignResult) {
+			unsaved[i] = r
+			job.Processed++
+			if r.Status != ItemFailed {
+				job.Succeeded++
+			} else {
+				job.Failed++
+			}
+			if r.Code == "circuit_open" {
+				circuitOpen = true
+			}
+			if job.Processed%bulkProgressEvery == 0 && job.Processed < job.Total {
+				save()
+			}
+		})
+		job.Status = "done"
+		if circuitOpen {
+			job.Status = "failed"
+			job.Error = "circuit breaker open, remaining users were not attempted"
+		}
+		job.FinishedAt = time.Now()
+		save()
+	}()
+	return job.JobID, nil
+}
+
+func (s *Service) GetBulkAssignJob(ctx context.Context, jobID string) (*cache.BulkAssignJob, error) {
+	return s.cache.GetBulkAssignJob(ctx, jobID)
+}
+
+// assignRoleToUsers fans the assignments out over a bounded worker pool.
+// progress, if set, is called once per user, never concurrently.
+func (s *Service) assignRoleToUsers(ctx context.Context, roleID string, users []string, o assignOptions, progress func(int, cache.BulkAssignResult)) []cache.BulkAssignResult {
+	results := make([]cache.BulkAssignResult, len(users))
+	var (
+		mu          sync.Mutex
+		wg          sync.WaitGroup
+		breakerOpen atomic.Bool
+	)
+	done := func(i int, r cache.BulkAssignResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[i] = r
+		if progress != nil {
+			progress(i, r)
+		}
+	}
+	skip := func(i int) bool {
+		switch {
+		case breakerOpen.Load():
+			done(i, cache.BulkAssignResult{UserID: users[i], Status: ItemFailed, Code: "circuit_open", Error: "not attempted: circuit breaker open"})
+		case ctx.Err() != nil:
+			done(i, cache.BulkAssignResult{UserID: users[i], Status: ItemFailed, Code: errorCode(ctx.Err()), Error: ctx.Err().Error()})
+		default:
+			return false
+		}
+		return true
+	}
+
+	sem := make(chan struct{}, s.bulkConcurrency)
+	for i := range users {
+		if skip(i) {
+			continue
+		}
+		sem <- struct{}{}
+		if skip(i) {
+			<-sem
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			r := s.assignOne(ctx, roleID, users[i], o)
+			if r.Code == "circuit_open" {
+				breakerOpen.Store(true)
+			}
+			done(i, r)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+func (s *Service) assignOne(ctx context.Context, roleID, userID string, o assignOptions) cache.BulkAssignResult {
+	// like AssignRolesBatch, a role the user already holds is left as it is
+	held := false
+	op := func() error {
+		roles, err := s.zitadel.GetUserRoles(ctx, userID)
+		if err == nil {
+			held = hasString(roles, roleID)
+			if !held {
+				err = s.zitadel.AssignRoleToUser(ctx, roleID, userID)
+			}
+		}
+		if err != nil && !breakerRejected(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	ebo := backoff.NewExponentialBackOff()
+	ebo.MaxElapsedTime = bulkBreakerWait
+
+	err := backoff.Retry(op, backoff.WithContext(ebo, ctx))
+	// a permanent assignment still cancels a pending expiry of a held role
+	if err == nil && !(held && !o.expiresAt.IsZero()) {
+		err = s.scheduleExpiry(ctx, roleID, userID, o)
+	}
+	if err == nil {
+		err = s.cache.InvalidateRoles(ctx, userID)
+	}
+	s.record(ctx, "role.assign", roleID, userID, err, o.details())
+	if err != nil {
+		return cache.BulkAssignResult{UserID: userID, Status: ItemFailed, Code: errorCode(err), Error: err.Error()}
+	}
+	if held {
+		return cache.BulkAssignResult{UserID: userID, Status: ItemExisted}
+	}
+	return cache.BulkAssignResult{UserID: userID, Status: ItemAssigned}
+}
+
+func breakerRejected(err error) bool {
+	return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)
+}
+
+func hasString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueStrings(in []string) []string {
+	seen := make(map[string]struct{}, len(in))
+	out := make([]string, 0, len(in))
+	for _, v := range in {
+		if _, ok := seen[v]; ok || v == "" {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
+	}
+	return out
+}
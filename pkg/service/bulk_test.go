@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AbduAllahGabbar/service/pkg/cache"
+)
+
+func TestAssignRoleToUsersExpiryKeepsHeldRole(t *testing.T) {
+	svc, z, c := newTestService()
+	z.grant("u1", "oncall")
+
+	results := svc.AssignRoleToUsers(context.Background(), "oncall", []string{"u1", "u2"}, WithExpiry(time.Now().Add(time.Hour)))
+	want := []string{ItemExisted, ItemAssigned}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("%s: status %q, want %q", r.UserID, r.Status, want[i])
+		}
+	}
+	if c.scheduled("oncall", "u1") {
+		t.Error("expiry scheduled for a user who already held the role")
+	}
+	if !c.scheduled("oncall", "u2") {
+		t.Error("no expiry scheduled for the newly granted user")
+	}
+}
+
+func TestAssignRoleToUsersReportsHeldRole(t *testing.T) {
+	svc, z, c := newTestService()
+	z.grant("u1", "oncall")
+	if err := c.ScheduleRoleExpiry(context.Background(), &cache.RoleAssignment{RoleID: "oncall", UserID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	results := svc.AssignRoleToUsers(context.Background(), "oncall", []string{"u1", "u2"})
+	want := []string{ItemExisted, ItemAssigned}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("%s: status %q, want %q", r.UserID, r.Status, want[i])
+		}
+	}
+	if c.scheduled("oncall", "u1") {
+		t.Error("permanent assignment left the pending expiry of a held role")
+	}
+}
+
+func TestStartAssignRoleToUsersSavesResultsIncrementally(t *testing.T) {
+	svc, _, c := newTestService()
+	users := make([]string, 3*bulkProgressEvery+1)
+	for i := range users {
+		users[i] = fmt.Sprintf("u%d", i)
+	}
+
+	jobID, err := svc.StartAssignRoleToUsers(context.Background(), "oncall", users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job *cache.BulkAssignJob
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		job, err = svc.GetBulkAssignJob(context.Background(), jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != "running" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if job.Status != "done" || job.Succeeded != len(users) {
+		t.Fatalf("job %s: status %q, succeeded %d of %d", jobID, job.Status, job.Succeeded, len(users))
+	}
+	for i, r := range job.Results {
+		if r.UserID != users[i] || r.Status != ItemAssigned {
+			t.Fatalf("result %d: %+v", i, r)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.jobWrites[0] != len(users) {
+		t.Fatalf("initial save wrote %d results, want %d", c.jobWrites[0], len(users))
+	}
+	total := 0
+	for _, n := range c.jobWrites[1:] {
+		if n > bulkProgressEvery {
+			t.Fatalf("progress save rewrote %d results, want at most %d", n, bulkProgressEvery)
+		}
+		total += n
+	}
+	if total != len(users) {
+		t.Fatalf("progress saves wrote %d results, want %d", total, len(users))
+	}
+}
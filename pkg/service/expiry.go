@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/AbduAllahGabbar/service/pkg/audit"
+	"github.com/AbduAllahGabbar/service/pkg/cache"
+	"github.com/AbduAllahGabbar/service/pkg/scope"
+)
+
+const (
+	expiryMaxAttempts = 5
+	expiryRetryDelay  = time.Minute
+	expiryBatchSize   = 100
+	// expiryClaimLease is how long a claimed expiry is left to one reaper
+	// before another may pick it up, should the first die mid-removal
+	expiryClaimLease = 5 * time.Minute
+)
+
+type assignOptions struct {
+	expiresAt time.Time
+}
+
+type AssignOption func(*assignOptions)
+
+// WithExpiry makes an assignment temporary: the grant is removed from Zitadel
+// by the expiry reaper once t has passed. The zero time means permanent.
+func WithExpiry(t time.Time) AssignOption {
+	return func(o *assignOptions) { o.expiresAt = t }
+}
+
+func (o assignOptions) details() map[string]string {
+	if o.expiresAt.IsZero() {
+		return nil
+	}
+	return map[string]string{"expires_at": o.expiresAt.UTC().Format(time.RFC3339)}
+}
+
+func buildAssignOptions(opts []AssignOption) assignOptions {
+	var o assignOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// scheduleExpiry records the expiry of a fresh grant, or cancels a pending one
+// when the grant is now permanent.
+func (s *Service) scheduleExpiry(ctx context.Context, roleID, userID string, o assignOptions) error {
+	if o.expiresAt.IsZero() {
+		_, err := s.cache.CancelRoleExpiry(ctx, roleID, userID)
+		return err
+	}
+	return s.cache.ScheduleRoleExpiry(ctx, &cache.RoleAssignment{
+		RoleID:    roleID,
+		UserID:    userID,
+		ExpiresAt: o.expiresAt.UTC(),
+		CreatedAt: time.Now().UTC(),
+		OrgID:     scope.OrgIDFrom(ctx),
+		Status:    "scheduled",
+	})
+}
+
+// heldRoles returns the roles the user holds before a temporary assignment,
+// so its expiry is not applied to them: a role held permanently must stay
+// permanent. It returns nil for permanent assignments.
+func (s *Service) heldRoles(ctx context.Context, userID string, o assignOptions) (map[string]bool, error) {
+	if o.expiresAt.IsZero() {
+		return nil, nil
+	}
+	roles, err := s.zitadel.GetUserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool, len(roles))
+	for _, r := range roles {
+		held[r] = true
+	}
+	return held, nil
+}
+
+func (s *Service) GetRoleAssignment(ctx context.Context, roleID, userID string) (*cache.RoleAssignment, bool, error) {
+	return s.cache.GetRoleAssignment(ctx, roleID, userID)
+}
+
+func (s *Service) ListExpiringAssignments(ctx context.Context) ([]*cache.RoleAssignment, error) {
+	return s.cache.ListScheduledExpiries(ctx)
+}
+
+func (s *Service) GetAssignmentHistory(ctx context.Context, limit int64) ([]*cache.RoleAssignment, error) {
+	return s.cache.GetAssignmentHistory(ctx, limit)
+}
+
+// ReapExpiredAssignments removes every lapsed temporary grant from Zitadel
+// and returns how many were removed. Failed removals are retried later and
+// recorded as failed after expiryMaxAttempts.
+func (s *Service) ReapExpiredAssignments(ctx context.Context) (int, error) {
+	if audit.ActorFrom(ctx) == "" {
+		ctx = audit.WithActor(ctx, "system:expiry-reaper")
+	}
+	removed := 0
+	for {
+		batch, err := s.cache.ClaimExpiredAssignments(ctx, time.Now(), expiryClaimLease, expiryBatchSize)
+		if err != nil {
+			return removed, err
+		}
+		for _, a := range batch {
+			if s.expire(ctx, a) {
+				removed++
+			}
+		}
+		if len(batch) < expiryBatchSize {
+			return removed, nil
+		}
+	}
+}
+
+func (s *Service) expire(ctx context.Context, a *cache.RoleAssignment) bool {
+	a.Attempts++
+	if a.OrgID != "" {
+		ctx = scope.WithOrgID(ctx, a.OrgID)
+	}
+	if a.ProjectID != "" {
+		ctx = scope.WithProjectID(ctx, a.ProjectID)
+	}
+	err := s.zitadel.RemoveRoleFromUser(ctx, a.RoleID, a.UserID)
+	s.record(ctx, "role.expire", a.RoleID, a.UserID, err, map[string]string{
+		"expires_at": a.ExpiresAt.Format(time.RFC3339),
+		"attempt":    strconv.Itoa(a.Attempts),
+	})
+	if err != nil {
+		log.Printf("expiry reaper: remove %s from %s failed (attempt %d): %v", a.RoleID, a.UserID, a.Attempts, err)
+		a.LastError = err.Error()
+		if a.Attempts < expiryMaxAttempts {
+			retry := *a
+			retry.RetryAt = time.Now().Add(expiryRetryDelay).UTC()
+			if err := s.cache.ScheduleRoleExpiry(ctx, &retry); err == nil {
+				return false
+			}
+		}
+		a.Status = "failed"
+	} else {
+		a.Status = "expired"
+		a.LastError = ""
+		if err := s.cache.InvalidateRoles(ctx, a.UserID); err != nil {
+			log.Printf("expiry reaper: invalidate roles of %s failed: %v", a.UserID, err)
+		}
+	}
+	a.DoneAt = time.Now().UTC()
+	if err := s.cache.RecordAssignmentOutcome(ctx, a); err != nil {
+		log.Printf("expiry reaper: record outcome for %s/%s failed: %v", a.UserID, a.RoleID, err)
+	}
+	return err == nil
+}
+
+// RunExpiryReaper calls ReapExpiredAssignments every interval until ctx is
+// done.
+func (s *Service) RunExpiryReaper(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if n, err := s.ReapExpiredAssignments(ctx); err != nil {
+			log.Printf("expiry reaper failed: %v", err)
+		} else if n > 0 {
+			log.Printf("expiry reaper: removed %d expired grants", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
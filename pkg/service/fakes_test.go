@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/AbduAllahGabbar/service/pkg/cache"
+	"github.com/AbduAllahGabbar/service/pkg/zitadel"
+)
+
+// fakeZitadel keeps grants in memory. Methods the tests do not need are left
+// to the embedded nil interface and panic when called.
+type fakeZitadel struct {
+	zitadel.Client
+
+	mu     sync.Mutex
+	grants map[string]map[string]bool
+}
+
+func newFakeZitadel() *fakeZitadel {
+	return &fakeZitadel{grants: map[string]map[string]bool{}}
+}
+
+func (z *fakeZitadel) grant(userID string, roles ...string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.grants[userID] == nil {
+		z.grants[userID] = map[string]bool{}
+	}
+	for _, r := range roles {
+		z.grants[userID][r] = true
+	}
+}
+
+func (z *fakeZitadel) AssignRoleToUser(ctx context.Context, roleID, userID string) error {
+	z.grant(userID, roleID)
+	return nil
+}
+
+func (z *fakeZitadel) AssignRolesToUser(ctx context.Context, userID string, roleIDs []string) error {
+	z.grant(userID, roleIDs...)
+	return nil
+}
+
+func (z *fakeZitadel) RemoveRoleFromUser(ctx context.Context, roleID, userID string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	delete(z.grants[userID], roleID)
+	return nil
+}
+
+func (z *fakeZitadel) GetUserRoles(ctx context.Context, userID string) ([]string, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	var out []string
+	for r := range z.grants[userID] {
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// fakeCache records scheduled expiries; like fakeZitadel it only implements
+// what the tests use.
+type fakeCache struct {
+	cache.Cache
+
+	mu       sync.Mutex
+	expiries map[string]*cache.RoleAssignment
+	jobs     map[string]*cache.BulkAssignJob
+	// jobWrites is the number of results written by each SaveBulkAssignJob
+	jobWrites []int
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{expiries: map[string]*cache.RoleAssignment{}, jobs: map[string]*cache.BulkAssignJob{}}
+}
+
+func (c *fakeCache) SaveBulkAssignJob(ctx context.Context, job *cache.BulkAssignJob, results map[int]cache.BulkAssignResult) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	saved, ok := c.jobs[job.JobID]
+	if !ok {
+		saved = &cache.BulkAssignJob{Results: make([]cache.BulkAssignResult, job.Total)}
+	}
+	stored := saved.Results
+	*saved = *job
+	saved.Results = stored
+	for i, r := range results {
+		saved.Results[i] = r
+	}
+	c.jobs[job.JobID] = saved
+	c.jobWrites = append(c.jobWrites, len(results))
+	return nil
+}
+
+func (c *fakeCache) GetBulkAssignJob(ctx context.Context, jobID string) (*cache.BulkAssignJob, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	job, ok := c.jobs[jobID]
+	if !ok {
+		return nil, cache.ErrBulkJobNotFound
+	}
+	cp := *job
+	cp.Results = append([]cache.BulkAssignResult(nil), job.Results...)
+	return &cp, nil
+}
+
+func (c *fakeCache) ScheduleRoleExpiry(ctx context.Context, a *cache.RoleAssignment) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expiries[a.UserID+"/"+a.RoleID] = a
+	return nil
+}
+
+func (c *fakeCache) CancelRoleExpiry(ctx context.Context, roleID, userID string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.expiries[userID+"/"+roleID]
+	delete(c.expiries, userID+"/"+roleID)
+	return ok, nil
+}
+
+func (c *fakeCache) GetRoleAssignment(ctx context.Context, roleID, userID string) (*cache.RoleAssignment, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a, ok := c.expiries[userID+"/"+roleID]
+	return a, ok, nil
+}
+
+func (c *fakeCache) InvalidateRoles(ctx context.Context, userID string) error { return nil }
+
+func (c *fakeCache) scheduled(roleID, userID string) bool {
+	_, ok, _ := c.GetRoleAssignment(context.Background(), roleID, userID)
+	return ok
+}
+
+func newTestService() (*Service, *fakeZitadel, *fakeCache) {
+	z, c := newFakeZitadel(), newFakeCache()
+	return New(z, c, time.Minute), z, c
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/AbduAllahGabbar/service/pkg/cache"
+)
+
+// CycleError is returned when an inheritance change would make a role imply
+// itself. Path starts and ends with the same role.
+type CycleError struct {
+	Path []string
+}
+
+func (e *CycleError) Error() string {
+	return "role inheritance cycle: " + strings.Join(e.Path, " -> ")
+}
+
+func (s *Service) GetRoleHierarchy(ctx context.Context) (*cache.RoleGraph, error) {
+	return s.cache.GetRoleGraph(ctx)
+}
+
+// SetRoleImplies replaces the roles directly implied by role (admin implies
+// editor, editor implies viewer, ...). The change is rejected with a
+// *CycleError if it would introduce a cycle.
+func (s *Service) SetRoleImplies(ctx context.Context, role string, implies []string) error {
+	role = strings.TrimSpace(role)
+	if role == "" {
+		return fmt.Errorf("role is required")
+	}
+	implies, err := normalizeRoles(implies)
+	if err == nil {
+		err = s.setRoleImplies(ctx, role, implies)
+	}
+	s.record(ctx, "role.hierarchy.set", role, "", err, map[string]string{"implies": strings.Join(implies, ",")})
+	return err
+}
+
+func (s *Service) setRoleImplies(ctx context.Context, role string, implies []string) error {
+	return s.cache.UpdateRoleGraph(ctx, func(g *cache.RoleGraph) error {
+		if len(implies) == 0 {
+			delete(g.Implies, role)
+		} else {
+			g.Implies[role] = implies
+		}
+		if path := findCycle(g.Implies); path != nil {
+			return &CycleError{Path: path}
+		}
+		g.Closure = transitiveClosure(g.Implies)
+		return nil
+	})
+}
+
+// ExpandRoles returns roles followed by every role they inherit, without
+// duplicates.
+func (s *Service) ExpandRoles(ctx context.Context, roles []string) ([]string, error) {
+	g, err := s.cache.GetRoleGraph(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return expandRoles(g, roles), nil
+}
+
+func (s *Service) GetEffectiveUserRoles(ctx context.Context, userID string) ([]string, error) {
+	roles, err := s.GetDirectUserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.ExpandRoles(ctx, roles)
+}
+
+func (s *Service) removeFromHierarchy(ctx context.Context, role string) error {
+	return s.cache.UpdateRoleGraph(ctx, func(g *cache.RoleGraph) error {
+		delete(g.Implies, role)
+		for parent, children := range g.Implies {
+			kept := children[:0]
+			for _, c := range children {
+				if c != role {
+					kept = append(kept, c)
+				}
+			}
+			if len(kept) == 0 {
+				delete(g.Implies, parent)
+			} else {
+				g.Implies[parent] = kept
+			}
+		}
+		g.Closure = transitiveClosure(g.Implies)
+		return nil
+	})
+}
+
+func expandRoles(g *cache.RoleGraph, roles []string) []string {
+	out := make([]string, 0, len(roles))
+	seen := make(map[string]struct{}, len(roles))
+	add := func(r string) {
+		if _, ok := seen[r]; !ok {
+			seen[r] = struct{}{}
+			out = append(out, r)
+		}
+	}
+	for _, r := range roles {
+		add(r)
+	}
+	for _, r := range roles {
+		for _, inherited := range g.Closure[r] {
+			add(inherited)
+		}
+	}
+	return out
+}
+
+// findCycle returns the first cycle found in graph as a path, or nil.
+func findCycle(graph map[string][]string) []string {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var stack []string
+	var visit func(string) []string
+	visit = func(n string) []string {
+		switch state[n] {
+		case visiting:
+			for i, v := range stack {
+				if v == n {
+					return append(append([]string{}, stack[i:]...), n)
+				}
+			}
+		case done:
+			return nil
+		}
+		state[n] = visiting
+		stack = append(stack, n)
+		for _, c := range graph[n] {
+			if p := visit(c); p != nil {
+				return p
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[n] = done
+		return nil
+	}
+	for _, n := range sortedKeys(graph) {
+		if p := visit(n); p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// transitiveClosure maps every role with outgoing edges to the sorted set of
+// roles reachable from it. graph must be acyclic.
+func transitiveClosure(graph map[string][]string) map[string][]string {
+	memo := map[string]map[string]struct{}{}
+	var reach func(string) map[string]struct{}
+	reach = func(n string) map[string]struct{} {
+		if r, ok := memo[n]; ok {
+			return r
+		}
+		r := map[string]struct{}{}
+		for _, c := range graph[n] {
+			r[c] = struct{}{}
+			for cc := range reach(c) {
+				r[cc] = struct{}{}
+			}
+		}
+		memo[n] = r
+		return r
+	}
+	closure := make(map[string][]string, len(graph))
+	for n := range graph {
+		set := reach(n)
+		roles := make([]string, 0, len(set))
+		for r := range set {
+			roles = append(roles, r)
+		}
+		sort.Strings(roles)
+		closure[n] = roles
+	}
+	return closure
+}
+
+func normalizeRoles(roles []string) ([]string, error) {
+	out := make([]string, 0, len(roles))
+	seen := make(map[string]struct{}, len(roles))
+	for _, r := range roles {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			return nil, fmt.Errorf("empty role key")
+		}
+		if _, ok := seen[r]; ok {
+			continue
+		}
+		seen[r] = struct{}{}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func (s *Service) GetRolePermissions(ctx context.Context, role string) ([]string, error) {
+	return s.cache.GetRolePermissions(ctx, role)
+}
+
+// SetRolePermissions replaces the permission set of role. Expanded user
+// permission caches are invalidated as a side effect.
+func (s *Service) SetRolePermissions(ctx context.Context, role string, perms []string) error {
+	perms, err := normalizePermissions(perms)
+	if err == nil {
+		err = s.cache.SetRolePermissions(ctx, role, perms)
+	}
+	s.record(ctx, "role.permissions.set", role, "", err, permissionDetails(perms))
+	return err
+}
+
+func (s *Service) AddRolePermissions(ctx context.Context, role string, perms []string) error {
+	perms, err := normalizePermissions(perms)
+	if err == nil {
+		err = s.cache.AddRolePermissions(ctx, role, perms)
+	}
+	s.record(ctx, "role.permissions.add", role, "", err, permissionDetails(perms))
+	return err
+}
+
+func (s *Service) RemoveRolePermissions(ctx context.Context, role string, perms []string) error {
+	err := s.cache.RemoveRolePermissions(ctx, role, perms)
+	s.record(ctx, "role.permissions.remove", role, "", err, permissionDetails(perms))
+	return err
+}
+
+func permissionDetails(perms []string) map[string]string {
+	return map[string]string{"permissions": strings.Join(perms, ",")}
+}
+
+// GetUserPermissions expands the user's effective roles into the union of
+// their permissions. The result is cached under the mapping version read
+// before expanding, so it is dropped if the mappings changed meanwhile.
+func (s *Service) GetUserPermissions(ctx context.Context, userID string) ([]string, error) {
+	version, verr := s.cache.PermissionsVersion(ctx)
+	if verr == nil {
+		if perms, ok, err := s.cache.GetUserPermissions(ctx, version, userID); err == nil && ok {
+			return perms, nil
+		}
+	}
+	roles, err := s.GetEffectiveUserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := s.cache.PermissionsForRoles(ctx, roles)
+	if err != nil {
+		return nil, err
+	}
+	if verr == nil {
+		_ = s.cache.SetUserPermissions(ctx, version, userID, perms, s.ttl)
+	}
+	return perms, nil
+}
+
+func normalizePermissions(perms []string) ([]string, error) {
+	out := make([]string, 0, len(perms))
+	seen := make(map[string]struct{}, len(perms))
+	for _, p := range perms {
+		p = strings.TrimSpace(p)
+		if p == "" || strings.ContainsAny(p, " \t\n") {
+			return nil, fmt.Errorf("invalid permission %q", p)
+		}
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		out = append(out, p)
+	}
+	return out, nil
+}
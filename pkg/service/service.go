@@ -8,28 +8,59 @@ import (
 	"github.com/cenkalti/backoff/v4"
 	"golang.org/x/sync/singleflight"
 
+	"github.com/AbduAllahGabbar/service/pkg/audit"
 	"github.com/AbduAllahGabbar/service/pkg/cache"
+	"github.com/AbduAllahGabbar/service/pkg/scope"
 	"github.com/AbduAllahGabbar/service/pkg/zitadel"
 )
 
+// Service operations act on the default project and organization of the
+// Zitadel client unless ctx names others (scope.WithProjectID,
+// scope.WithOrgID); caches are kept per project.
 type Service struct {
-	zitadel zitadel.Client
-	cache   cache.Cache
-	group   singleflight.Group
-	ttl     time.Duration
+	zitadel        zitadel.Client
+	cache          cache.Cache
+	group          singleflight.Group
+	ttl            time.Duration
+	effectiveRoles bool
+	approvers      map[string][]string
+	auditLog       audit.Sink
+
+	bulkConcurrency int
 }
 
-func New(z zitadel.Client, c cache.Cache, ttl time.Duration) *Service {
-	return &Service{zitadel: z, cache: c, ttl: ttl}
+type Option func(*Service)
+
+// WithEffectiveRoles makes GetUserRoles return the user's roles expanded
+// through the role inheritance graph instead of only the granted ones.
+func WithEffectiveRoles() Option {
+	return func(s *Service) { s.effectiveRoles = true }
+}
+
+func New(z zitadel.Client, c cache.Cache, ttl time.Duration, opts ...Option) *Service {
+	s := &Service{zitadel: z, cache: c, ttl: ttl, auditLog: audit.Nop(), bulkConcurrency: defaultBulkConcurrency}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *Service) GetUserRoles(ctx context.Context, userID string) ([]string, error) {
+	if s.effectiveRoles {
+		return s.GetEffectiveUserRoles(ctx, userID)
+	}
+	return s.GetDirectUserRoles(ctx, userID)
+}
+
+// GetDirectUserRoles returns the roles granted to the user in Zitadel,
+// ignoring inheritance.
+func (s *Service) GetDirectUserRoles(ctx context.Context, userID string) ([]string, error) {
 	if roles, ok, err := s.cache.GetRoles(ctx, userID); err == nil && ok {
 		return roles, nil
 	} else if err != nil {
 	}
 
-	v, err, _ := s.group.Do("roles:"+userID, func() (interface{}, error) {
+	v, err, _ := s.group.Do("roles:"+scope.ProjectIDFrom(ctx)+":"+userID, func() (interface{}, error) {
 		var roles []string
 		op := func() error {
 			r, e := s.zitadel.GetUserRoles(ctx, userID)
@@ -62,45 +93,210 @@ func (s *Service) GetUserRoles(ctx context.Context, userID string) ([]string, er
 }
 
 func (s *Service) CreateRole(ctx context.Context, name, desc string) (string, error) {
-	return s.zitadel.CreateRole(ctx, name, desc)
+	id, err := s.zitadel.CreateRole(ctx, name, desc)
+	s.record(ctx, "role.create", name, "", err, nil)
+	return id, err
 }
 
 func (s *Service) CreateRoles(ctx context.Context, roles []zitadel.RoleInput) ([]string, error) {
-	return s.zitadel.CreateRoles(ctx, roles)
+	ids, err := s.zitadel.CreateRoles(ctx, roles)
+	for _, r := range roles {
+		s.record(ctx, "role.create", r.Name, "", err, nil)
+	}
+	return ids, err
+}
+
+// EnsureRoles creates the roles that are missing and treats existing ones as
+// success, optionally updating their display name. See
+// zitadel.Client.EnsureRoles.
+func (s *Service) EnsureRoles(ctx context.Context, roles []zitadel.RoleInput, updateDisplayName bool) ([]zitadel.RoleResult, error) {
+	results, err := s.zitadel.EnsureRoles(ctx, roles, updateDisplayName)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range results {
+		switch {
+		case r.Status == zitadel.RoleCreated:
+			s.record(ctx, "role.create", r.Key, "", nil, nil)
+		case r.Updated:
+			s.record(ctx, "role.update", r.Key, "", nil, map[string]string{"display_name": roles[i].Desc})
+		case r.Status == zitadel.RoleFailed:
+			s.record(ctx, "role.create", r.Key, "", errors.New(r.Error), nil)
+		}
+	}
+	return results, nil
+}
+
+var ErrRoleNotFound = errors.New("role not found")
+
+// RoleUpdate changes the fields that are set and keeps the others.
+type RoleUpdate struct {
+	DisplayName *string
+	Group       *string
+}
+
+// UpdateRole changes a role's display name and/or group and returns the
+// updated role.
+func (s *Service) UpdateRole(ctx context.Context, key string, u RoleUpdate) (*zitadel.Role, error) {
+	role, err := s.updateRole(ctx, key, u)
+	details := map[string]string{}
+	if u.DisplayName != nil {
+		details["display_name"] = *u.DisplayName
+	}
+	if u.Group != nil {
+		details["group"] = *u.Group
+	}
+	s.record(ctx, "role.update", key, "", err, details)
+	return role, err
+}
+
+func (s *Service) updateRole(ctx context.Context, key string, u RoleUpdate) (*zitadel.Role, error) {
+	list, err := s.zitadel.ListRoles(ctx, zitadel.RoleQuery{Key: key})
+	if err != nil {
+		return nil, err
+	}
+	var role *zitadel.Role
+	for i := range list.Roles {
+		if list.Roles[i].Key == key {
+			role = &list.Roles[i]
+		}
+	}
+	if role == nil {
+		return nil, ErrRoleNotFound
+	}
+	if u.DisplayName != nil {
+		role.DisplayName = *u.DisplayName
+	}
+	if u.Group != nil {
+		role.Group = *u.Group
+	}
+	if err := s.zitadel.UpdateRole(ctx, key, role.DisplayName, role.Group); err != nil {
+		return nil, err
+	}
+	return role, nil
 }
 
-func (s *Service) AssignRole(ctx context.Context, roleID, userID string) error {
+func (s *Service) AssignRole(ctx context.Context, roleID, userID string, opts ...AssignOption) error {
+	o := buildAssignOptions(opts)
+	err := s.assignRole(ctx, roleID, userID, o)
+	s.record(ctx, "role.assign", roleID, userID, err, o.details())
+	return err
+}
+
+// assignRole grants roleID to the user. Assigning a role the user already
+// holds succeeds without changing the grant, so an expiry is not applied to
+// it.
+func (s *Service) assignRole(ctx context.Context, roleID, userID string, o assignOptions) error {
+	held, err := s.heldRoles(ctx, userID, o)
+	if err != nil {
+		return err
+	}
 	if err := s.zitadel.AssignRoleToUser(ctx, roleID, userID); err != nil {
 		return err
 	}
+	if !held[roleID] {
+		if err := s.scheduleExpiry(ctx, roleID, userID, o); err != nil {
+			return err
+		}
+	}
 	return s.cache.InvalidateRoles(ctx, userID)
 }
 
-func (s *Service) AssignRolesToUser(ctx context.Context, userID string, roleIDs []string) error {
+func (s *Service) AssignRolesToUser(ctx context.Context, userID string, roleIDs []string, opts ...AssignOption) error {
 	if len(roleIDs) == 0 {
 		return nil
 	}
+	o := buildAssignOptions(opts)
+	err := s.assignRolesToUser(ctx, userID, roleIDs, o)
+	for _, roleID := range roleIDs {
+		s.record(ctx, "role.assign", roleID, userID, err, o.details())
+	}
+	return err
+}
+
+// assignRolesToUser grants roleIDs to the user; like assignRole, an expiry
+// only applies to the roles the user did not hold yet.
+func (s *Service) assignRolesToUser(ctx context.Context, userID string, roleIDs []string, o assignOptions) error {
+	held, err := s.heldRoles(ctx, userID, o)
+	if err != nil {
+		return err
+	}
 	if err := s.zitadel.AssignRolesToUser(ctx, userID, roleIDs); err != nil {
 		return err
 	}
+	for _, roleID := range roleIDs {
+		if held[roleID] {
+			continue
+		}
+		if err := s.scheduleExpiry(ctx, roleID, userID, o); err != nil {
+			return err
+		}
+	}
 	return s.cache.InvalidateRoles(ctx, userID)
 }
 
 func (s *Service) DeleteRole(ctx context.Context, roleID string) error {
+	_, err := s.DeleteRoleWithJob(ctx, roleID)
+	return err
+}
+
+// DeleteRoleWithJob is DeleteRole returning the ID of the cache cleanup job
+// it started.
+func (s *Service) DeleteRoleWithJob(ctx context.Context, roleID string) (string, error) {
+	jobID, err := s.deleteRole(ctx, roleID)
+	s.record(ctx, "role.delete", roleID, "", err, nil)
+	return jobID, err
+}
+
+func (s *Service) deleteRole(ctx context.Context, roleID string) (string, error) {
 	if err := s.zitadel.DeleteRole(ctx, roleID); err != nil {
-		return err
+		return "", err
 	}
-	_, err := s.cache.StartRemoveRoleJob(ctx, roleID)
-	return err
+	if err := s.cache.DeleteRolePermissions(ctx, roleID); err != nil {
+		return "", err
+	}
+	if err := s.removeFromHierarchy(ctx, roleID); err != nil {
+		return "", err
+	}
+	return s.cache.StartRemoveRoleJob(ctx, roleID)
 }
 
 func (s *Service) RemoveRoleFromUser(ctx context.Context, roleID, userID string) error {
+	err := s.removeRoleFromUser(ctx, roleID, userID)
+	s.record(ctx, "role.remove", roleID, userID, err, nil)
+	return err
+}
+
+func (s *Service) removeRoleFromUser(ctx context.Context, roleID, userID string) error {
 	if err := s.zitadel.RemoveRoleFromUser(ctx, roleID, userID); err != nil {
 		return err
 	}
+	if _, err := s.cache.CancelRoleExpiry(ctx, roleID, userID); err != nil {
+		return err
+	}
 	return s.cache.InvalidateRoles(ctx, userID)
 }
 
+// GetCachedUserRoles returns the roles cached for the user, if any, without
+// falling back to Zitadel.
+func (s *Service) GetCachedUserRoles(ctx context.Context, userID string) ([]string, bool, error) {
+	return s.cache.GetRoles(ctx, userID)
+}
+
+// GetLiveUserRoles asks Zitadel for the user's roles, bypassing the cache.
+func (s *Service) GetLiveUserRoles(ctx context.Context, userID string) ([]string, error) {
+	return s.zitadel.GetUserRoles(ctx, userID)
+}
+
+func (s *Service) ListRoles(ctx context.Context, q zitadel.RoleQuery) (*zitadel.RoleList, error) {
+	return s.zitadel.ListRoles(ctx, q)
+}
+
+// ListUsersWithRole returns a page of the users granted roleKey directly.
+func (s *Service) ListUsersWithRole(ctx context.Context, roleKey string, offset, limit int) (*zitadel.RoleGrantList, error) {
+	return s.zitadel.ListUsersWithRole(ctx, roleKey, offset, limit)
+}
+
 func (s *Service) InvalidateRoles(ctx context.Context, userID string) error {
 	return s.cache.InvalidateRoles(ctx, userID)
 }
@@ -112,3 +308,11 @@ func (s *Service) StartRemoveRoleCleanup(ctx context.Context, role string) (stri
 func (s *Service) GetCleanupJobStatus(ctx context.Context, jobID string) (*cache.CleanupJobStatus, error) {
 	return s.cache.GetJobStatus(ctx, jobID)
 }
+
+func (s *Service) GetTokenEntry(ctx context.Context, token string) (*cache.TokenEntry, bool, error) {
+	return s.cache.GetTokenEntry(ctx, token)
+}
+
+func (s *Service) SetTokenEntry(ctx context.Context, token string, entry *cache.TokenEntry, ttl time.Duration) error {
+	return s.cache.SetTokenEntry(ctx, token, entry, ttl)
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAssignRoleExpiryKeepsHeldRolePermanent(t *testing.T) {
+	svc, z, c := newTestService()
+	z.grant("u1", "admin")
+	ctx := context.Background()
+	exp := WithExpiry(time.Now().Add(time.Hour))
+
+	if err := svc.AssignRole(ctx, "admin", "u1", exp); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.AssignRolesToUser(ctx, "u1", []string{"admin", "oncall"}, exp); err != nil {
+		t.Fatal(err)
+	}
+	if c.scheduled("admin", "u1") {
+		t.Error("expiry scheduled for a role the user already held")
+	}
+	if !c.scheduled("oncall", "u1") {
+		t.Error("no expiry scheduled for the newly granted role")
+	}
+}
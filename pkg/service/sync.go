@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+
+	"github.com/AbduAllahGabbar/service/pkg/rolesync"
+	"github.com/AbduAllahGabbar/service/pkg/zitadel"
+)
+
+// PlanRoleSync diffs the manifest against the roles and grants Zitadel
+// currently reports, bypassing the cache.
+func (s *Service) PlanRoleSync(ctx context.Context, m *rolesync.Manifest, opts rolesync.Options) (*rolesync.Plan, error) {
+	return rolesync.BuildPlan(ctx, s.zitadel, m, opts)
+}
+
+// ApplyRoleSync applies a plan through the service so caches, permission
+// mappings and the audit log follow the changes.
+func (s *Service) ApplyRoleSync(ctx context.Context, p *rolesync.Plan) (*rolesync.Result, error) {
+	return rolesync.Apply(ctx, syncTarget{s}, p)
+}
+
+type syncTarget struct {
+	s *Service
+}
+
+func (t syncTarget) CreateRoles(ctx context.Context, roles []zitadel.RoleInput) ([]string, error) {
+	return t.s.CreateRoles(ctx, roles)
+}
+
+func (t syncTarget) UpdateRole(ctx context.Context, key, displayName, group string) error {
+	_, err := t.s.UpdateRole(ctx, key, RoleUpdate{DisplayName: &displayName, Group: &group})
+	return err
+}
+
+func (t syncTarget) DeleteRole(ctx context.Context, roleID string) error {
+	return t.s.DeleteRole(ctx, roleID)
+}
+
+func (t syncTarget) AssignRolesToUser(ctx context.Context, userID string, roleIDs []string) error {
+	return t.s.AssignRolesToUser(ctx, userID, roleIDs)
+}
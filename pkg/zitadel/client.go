@@ -15,11 +15,19 @@ import (
 	"github.com/sony/gobreaker"
 
 	"github.com/AbduAllahGabbar/service/pkg/config"
+	"github.com/AbduAllahGabbar/service/pkg/scope"
 )
 
 type RoleInput struct {
-	Name string `json:"name"`
-	Desc string `json:"desc,omitempty"`
+	Name  string `json:"name"`
+	Desc  string `json:"desc,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+type Role struct {
+	Key         string `json:"key"`
+	DisplayName string `json:"display_name"`
+	Group       string `json:"group,omitempty"`
 }
 
 type Client interface {
@@ -30,17 +38,32 @@ type Client interface {
 	DeleteRole(ctx context.Context, roleID string) error
 	RemoveRoleFromUser(ctx context.Context, roleID, userID string) error
 	GetUserRoles(ctx context.Context, userID string) ([]string, error)
+	ListRoles(ctx context.Context, q RoleQuery) (*RoleList, error)
+	UpdateRole(ctx context.Context, key, displayName, group string) error
+	EnsureRoles(ctx context.Context, roles []RoleInput, updateDisplayName bool) ([]RoleResult, error)
+	ListUsersWithRole(ctx context.Context, roleKey string, offset, limit int) (*RoleGrantList, error)
 }
 
 type httpClient struct {
 	base    *url.URL
 	cli     *retryablehttp.Client
-	token   string
+	tokens  TokenSource
 	cb      *gobreaker.CircuitBreaker
 	project string
+	// org is the default organization; empty leaves it to Zitadel (the
+	// service account's org).
+	org string
+	// grants serializes the read-modify-write of each user's project grant
+	grants keyLocks
 }
 
 func NewHTTPClient(baseURL, token string, cfg *config.Config) Client {
+	return NewHTTPClientWithTokenSource(baseURL, StaticTokenSource(token), cfg)
+}
+
+// NewHTTPClientWithTokenSource is NewHTTPClient taking its bearer tokens from
+// tokens; see NewTokenSource.
+func NewHTTPClientWithTokenSource(baseURL string, tokens TokenSource, cfg *config.Config) Client {
 	u, _ := url.Parse(baseURL)
 
 	cli := retryablehttp.NewClient()
@@ -70,9 +93,10 @@ func NewHTTPClient(baseURL, token string, cfg *config.Config) Client {
 	return &httpClient{
 		base:    u,
 		cli:     cli,
-		token:   token,
+		tokens:  tokens,
 		cb:      cb,
 		project: cfg.ProjectID,
+		org:     cfg.ZitadelOrgID,
 	}
 }
 
@@ -85,9 +109,32 @@ func (h *httpClient) makeURL(p string) string {
 	return u.String()
 }
 
+// doRequest sends req with the current access token. A 401 is retried once
+// with a fresh token when the token source can issue one.
 func (h *httpClient) doRequest(req *retryablehttp.Request) (*http.Response, error) {
-	req.Header.Set("Authorization", "Bearer " + h.token)
+	token, err := h.tokens.Token(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("get access token: %w", err)
+	}
+	resp, err := h.execute(req, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !h.tokens.Invalidate(token) {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	token, err = h.tokens.Token(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("refresh access token: %w", err)
+	}
+	return h.execute(req, token)
+}
+
+func (h *httpClient) execute(req *retryablehttp.Request, token string) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer " + token)
 	req.Header.Set("Content-Type", "application/json")
+	if org := h.orgID(req.Context()); org != "" {
+		req.Header.Set(HeaderOrgID, org)
+	}
 
 	res, err := h.cb.Execute(func() (interface{}, error) {
 		r, e := h.cli.Do(req)
@@ -107,6 +154,20 @@ func (h *httpClient) doRequest(req *retryablehttp.Request) (*http.Response, erro
 	return nil, fmt.Errorf("unexpected response type from cb")
 }
 
+func (h *httpClient) projectID(ctx context.Context) string {
+	if p := scope.ProjectIDFrom(ctx); p != "" {
+		return p
+	}
+	return h.project
+}
+
+func (h *httpClient) orgID(ctx context.Context) string {
+	if org := scope.OrgIDFrom(ctx); org != "" {
+		return org
+	}
+	return h.org
+}
+
 func (h *httpClient) CreateRoles(ctx context.Context, roles []RoleInput) ([]string, error) {
 	type bulkRole struct {
 		Key         string `json:"key"`
@@ -115,10 +176,14 @@ func (h *httpClient) CreateRoles(ctx context.Context, roles []RoleInput) ([]stri
 	}
 	br := make([]bulkRole, 0, len(roles))
 	for _, r := range roles {
+		group := r.Group
+		if group == "" {
+			group = "default"
+		}
 		br = append(br, bulkRole{
 			Key:         r.Name,
 			DisplayName: r.Desc,
-			Group:       "default",
+			Group:       group,
 		})
 	}
 	payload := map[string]interface{}{
@@ -126,7 +191,7 @@ func (h *httpClient) CreateRoles(ctx context.Context, roles []RoleInput) ([]stri
 	}
 	b, _ := json.Marshal(payload)
 
-	endpoint := fmt.Sprintf("/management/v1/projects/%s/roles/_bulk", h.project)
+	endpoint := fmt.Sprintf("/management/v1/projects/%s/roles/_bulk", h.projectID(ctx))
 	req, _ := retryablehttp.NewRequest("POST", h.makeURL(endpoint), strings.NewReader(string(b)))
 	req = req.WithContext(ctx)
 
@@ -138,7 +203,7 @@ func (h *httpClient) CreateRoles(ctx context.Context, roles []RoleInput) ([]stri
 
 	if resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("create roles bulk failed: %d %s", resp.StatusCode, string(body))
+		return nil, &HTTPError{Op: "create roles bulk", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var out struct {
@@ -169,56 +234,42 @@ func (h *httpClient) CreateRole(ctx context.Context, name, desc string) (string,
 }
 
 func (h *httpClient) AssignRoleToUser(ctx context.Context, roleID, userID string) error {
-	payload := map[string]interface{}{
-		"projectId": h.project,
-		"roleKeys":  []string{roleID},
-	}
-	b, _ := json.Marshal(payload)
-
-	endpoint := fmt.Sprintf("/management/v1/users/%s/grants", userID)
-	req, _ := retryablehttp.NewRequest("POST", h.makeURL(endpoint), strings.NewReader(string(b)))
-	req = req.WithContext(ctx)
-
-	resp, err := h.doRequest(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("assign role failed: %d %s", resp.StatusCode, string(body))
-	}
-	return nil
+	return h.AssignRolesToUser(ctx, userID, []string{roleID})
 }
 
+// AssignRolesToUser adds the roles to the user's project grant, creating the
+// grant if the user has none. Roles the user already holds are skipped, so
+// repeating an assignment is a no-op.
 func (h *httpClient) AssignRolesToUser(ctx context.Context, userID string, roleIDs []string) error {
 	if len(roleIDs) == 0 {
 		return nil
 	}
-	payload := map[string]interface{}{
-		"projectId": h.project,
-		"roleKeys":  roleIDs,
-	}
-	b, _ := json.Marshal(payload)
-	endpoint := fmt.Sprintf("/management/v1/users/%s/grants", userID)
-	req, _ := retryablehttp.NewRequest("POST", h.makeURL(endpoint), strings.NewReader(string(b)))
-	req = req.WithContext(ctx)
-
-	resp, err := h.doRequest(req)
-	if err != nil {
+	defer h.lockGrant(ctx, userID)()
+	// another process may create the grant between the lookup and the
+	// create; look it up again and merge into it in that case
+	for attempt := 0; ; attempt++ {
+		grant, err := h.findProjectGrant(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if grant != nil {
+			keys, changed := mergeRoleKeys(grant.RoleKeys, roleIDs)
+			if !changed {
+				return nil
+			}
+			return h.updateGrant(ctx, userID, grant.ID, keys)
+		}
+		keys, _ := mergeRoleKeys(nil, roleIDs)
+		err = h.createGrant(ctx, userID, keys)
+		if IsStatus(err, http.StatusConflict) && attempt == 0 {
+			continue
+		}
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("assign roles failed: %d %s", resp.StatusCode, string(body))
-	}
-	return nil
 }
 
 func (h *httpClient) DeleteRole(ctx context.Context, roleID string) error {
-	endpoint := fmt.Sprintf("/management/v1/projects/%s/roles/%s", h.project, roleID)
+	endpoint := fmt.Sprintf("/management/v1/projects/%s/roles/%s", h.projectID(ctx), roleID)
 	req, _ := retryablehttp.NewRequest("DELETE", h.makeURL(endpoint), nil)
 	req = req.WithContext(ctx)
 
@@ -229,66 +280,38 @@ func (h *httpClient) DeleteRole(ctx context.Context, roleID string) error {
 	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("delete role failed: %d %s", resp.StatusCode, string(body))
+		return &HTTPError{Op: "delete role", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 	return nil
 }
 
+// RemoveRoleFromUser removes the role from the user's project grant, and
+// deletes the grant once no roles are left on it.
 func (h *httpClient) RemoveRoleFromUser(ctx context.Context, roleID, userID string) error {
-	searchPayload := map[string]interface{}{
-		"queries": []interface{}{
-			map[string]interface{}{
-				"user_id_query": map[string]string{
-					"user_id": userID,
-				},
-			},
-		},
-	}
-	b, _ := json.Marshal(searchPayload)
-	searchEndpoint := "/management/v1/users/grants/_search"
-	req, _ := retryablehttp.NewRequest("POST", h.makeURL(searchEndpoint), strings.NewReader(string(b)))
-	req = req.WithContext(ctx)
-
-	resp, err := h.doRequest(req)
+	defer h.lockGrant(ctx, userID)()
+	grant, err := h.findProjectGrant(ctx, userID)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("search grants failed: %d %s", resp.StatusCode, string(body))
-	}
-
-	var out struct {
-		Result []struct {
-			GrantId  string   `json:"grantId"`
-			ID       string   `json:"id"`
-			RoleKeys []string `json:"roleKeys"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return fmt.Errorf("failed to decode grants search: %w", err)
-	}
-
-	var grantToDelete string
-	for _, r := range out.Result {
-		for _, role := range r.RoleKeys {
-			if role == roleID {
-				if r.GrantId != "" {
-					grantToDelete = r.GrantId
-				} else {
-					grantToDelete = r.ID
-				}
-				break
+	var remaining []string
+	found := false
+	if grant != nil {
+		for _, k := range grant.RoleKeys {
+			if k == roleID {
+				found = true
+				continue
 			}
+			remaining = append(remaining, k)
 		}
 	}
-
-	if grantToDelete == "" {
+	if !found {
 		return fmt.Errorf("grant for user %s and role %s not found", userID, roleID)
 	}
+	if len(remaining) > 0 {
+		return h.updateGrant(ctx, userID, grant.ID, remaining)
+	}
 
-	delEndpoint := fmt.Sprintf("/management/v1/users/%s/grants/%s", userID, grantToDelete)
+	delEndpoint := fmt.Sprintf("/management/v1/users/%s/grants/%s", userID, grant.ID)
 	delReq, _ := retryablehttp.NewRequest("DELETE", h.makeURL(delEndpoint), nil)
 	delReq = delReq.WithContext(ctx)
 	delResp, err := h.doRequest(delReq)
@@ -298,7 +321,7 @@ func (h *httpClient) RemoveRoleFromUser(ctx context.Context, roleID, userID stri
 	defer delResp.Body.Close()
 	if delResp.StatusCode >= 300 {
 		body, _ := io.ReadAll(delResp.Body)
-		return fmt.Errorf("delete grant failed: %d %s", delResp.StatusCode, string(body))
+		return &HTTPError{Op: "delete grant", StatusCode: delResp.StatusCode, Body: string(body)}
 	}
 	return nil
 }
@@ -311,6 +334,11 @@ func (h *httpClient) GetUserRoles(ctx context.Context, userID string) ([]string,
 					"user_id": userID,
 				},
 			},
+			map[string]interface{}{
+				"project_id_query": map[string]string{
+					"project_id": h.projectID(ctx),
+				},
+			},
 		},
 	}
 	b, _ := json.Marshal(payload)
@@ -327,7 +355,7 @@ func (h *httpClient) GetUserRoles(ctx context.Context, userID string) ([]string,
 
 	if resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("get user roles failed: %d %s", resp.StatusCode, string(body))
+		return nil, &HTTPError{Op: "get user roles", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var out struct {
@@ -0,0 +1,5 @@
+package zitadel
+
+// HeaderOrgID selects the organization a management API call runs in; the
+// client sets it from scope.OrgIDFrom.
+const HeaderOrgID = "x-zitadel-orgid"
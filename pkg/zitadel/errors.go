@@ -0,0 +1,23 @@
+package zitadel
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HTTPError is returned when Zitadel answers with a non-2xx status.
+type HTTPError struct {
+	Op         string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s failed: %d %s", e.Op, e.StatusCode, e.Body)
+}
+
+// IsStatus reports whether err is an HTTPError with the given status code.
+func IsStatus(err error, code int) bool {
+	var he *HTTPError
+	return errors.As(err, &he) && he.StatusCode == code
+}
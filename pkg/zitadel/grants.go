@@ -0,0 +1,268 @@
+package zitadel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// RoleGrant is a user grant in the project that includes a given role.
+type RoleGrant struct {
+	UserID    string    `json:"user_id"`
+	GrantID   string    `json:"grant_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RoleGrantList struct {
+	Grants []RoleGrant `json:"grants"`
+	Total  int         `json:"total"`
+}
+
+// ListUsersWithRole returns one page of the project grants that include
+// roleKey. A zero limit uses the largest page Zitadel allows.
+func (h *httpClient) ListUsersWithRole(ctx context.Context, roleKey string, offset, limit int) (*RoleGrantList, error) {
+	if limit <= 0 {
+		limit = listRolesPageSize
+	}
+	payload := map[string]interface{}{
+		"query": map[string]interface{}{
+			"offset": strconv.Itoa(offset),
+			"limit":  limit,
+			"asc":    true,
+		},
+		"queries": []interface{}{
+			map[string]interface{}{
+				"role_key_query": map[string]string{
+					"role_key": roleKey,
+					"method":   "TEXT_QUERY_METHOD_EQUALS",
+				},
+			},
+			map[string]interface{}{
+				"project_id_query": map[string]string{
+					"project_id": h.projectID(ctx),
+				},
+			},
+		},
+	}
+	b, _ := json.Marshal(payload)
+
+	endpoint := "/management/v1/users/grants/_search"
+	req, _ := retryablehttp.NewRequest("POST", h.makeURL(endpoint), strings.NewReader(string(b)))
+	req = req.WithContext(ctx)
+
+	resp, err := h.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &HTTPError{Op: "search grants", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var out struct {
+		Details struct {
+			TotalResult string `json:"totalResult"`
+		} `json:"details"`
+		Result []struct {
+			ID      string `json:"id"`
+			GrantId string `json:"grantId"`
+			UserID  string `json:"userId"`
+			Details struct {
+				CreationDate time.Time `json:"creationDate"`
+			} `json:"details"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode grants search: %w", err)
+	}
+
+	list := &RoleGrantList{Grants: make([]RoleGrant, 0, len(out.Result))}
+	list.Total, _ = strconv.Atoi(out.Details.TotalResult)
+	for _, r := range out.Result {
+		id := r.ID
+		if id == "" {
+			id = r.GrantId
+		}
+		list.Grants = append(list.Grants, RoleGrant{UserID: r.UserID, GrantID: id, CreatedAt: r.Details.CreationDate})
+	}
+	return list, nil
+}
+
+// projectGrant is the user's grant on the client's project. Zitadel allows
+// one per user and project.
+type projectGrant struct {
+	ID       string
+	RoleKeys []string
+}
+
+// findProjectGrant returns the user's project grant, or nil if there is none.
+// More than one grant is an error, as merging into either would leave the
+// roles split.
+func (h *httpClient) findProjectGrant(ctx context.Context, userID string) (*projectGrant, error) {
+	payload := map[string]interface{}{
+		"queries": []interface{}{
+			map[string]interface{}{
+				"user_id_query": map[string]string{
+					"user_id": userID,
+				},
+			},
+			map[string]interface{}{
+				"project_id_query": map[string]string{
+					"project_id": h.projectID(ctx),
+				},
+			},
+		},
+	}
+	b, _ := json.Marshal(payload)
+
+	endpoint := "/management/v1/users/grants/_search"
+	req, _ := retryablehttp.NewRequest("POST", h.makeURL(endpoint), strings.NewReader(string(b)))
+	req = req.WithContext(ctx)
+
+	resp, err := h.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &HTTPError{Op: "search grants", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var out struct {
+		Result []struct {
+			ID       string   `json:"id"`
+			GrantId  string   `json:"grantId"`
+			RoleKeys []string `json:"roleKeys"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode grants search: %w", err)
+	}
+	if len(out.Result) == 0 {
+		return nil, nil
+	}
+	if len(out.Result) > 1 {
+		return nil, fmt.Errorf("user %s has %d grants on project %s", userID, len(out.Result), h.projectID(ctx))
+	}
+	r := out.Result[0]
+	id := r.ID
+	if id == "" {
+		id = r.GrantId
+	}
+	return &projectGrant{ID: id, RoleKeys: r.RoleKeys}, nil
+}
+
+func (h *httpClient) createGrant(ctx context.Context, userID string, roleKeys []string) error {
+	payload := map[string]interface{}{
+		"projectId": h.projectID(ctx),
+		"roleKeys":  roleKeys,
+	}
+	b, _ := json.Marshal(payload)
+	endpoint := fmt.Sprintf("/management/v1/users/%s/grants", userID)
+	req, _ := retryablehttp.NewRequest("POST", h.makeURL(endpoint), strings.NewReader(string(b)))
+	req = req.WithContext(ctx)
+
+	resp, err := h.doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return &HTTPError{Op: "assign roles", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return nil
+}
+
+func (h *httpClient) updateGrant(ctx context.Context, userID, grantID string, roleKeys []string) error {
+	payload := map[string]interface{}{
+		"roleKeys": roleKeys,
+	}
+	b, _ := json.Marshal(payload)
+	endpoint := fmt.Sprintf("/management/v1/users/%s/grants/%s", userID, grantID)
+	req, _ := retryablehttp.NewRequest("PUT", h.makeURL(endpoint), strings.NewReader(string(b)))
+	req = req.WithContext(ctx)
+
+	resp, err := h.doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return &HTTPError{Op: "update grant", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return nil
+}
+
+// mergeRoleKeys appends the keys of add missing from have. It reports false
+// when nothing was missing.
+func mergeRoleKeys(have, add []string) ([]string, bool) {
+	seen := make(map[string]struct{}, len(have))
+	merged := append([]string(nil), have...)
+	for _, k := range have {
+		seen[k] = struct{}{}
+	}
+	changed := false
+	for _, k := range add {
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		merged = append(merged, k)
+		changed = true
+	}
+	return merged, changed
+}
+
+// lockGrant holds the user's grant lock for the project of ctx until the
+// returned func is called. It only orders the calls of this client; other
+// processes are caught by the conflict on create.
+func (h *httpClient) lockGrant(ctx context.Context, userID string) func() {
+	return h.grants.lock(h.orgID(ctx) + "/" + h.projectID(ctx) + "/" + userID)
+}
+
+// keyLocks is a set of mutexes by key, created on demand and dropped once
+// unused. The zero value is ready to use.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (k *keyLocks) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyLock)
+	}
+	l := k.locks[key]
+	if l == nil {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,36 @@
+package zitadel
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AbduAllahGabbar/service/pkg/oidc"
+)
+
+const (
+	jwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	assertionTTL       = time.Hour
+)
+
+// NewJWTProfileSource obtains tokens with the JWT profile grant: it signs an
+// assertion with the service-account key, audienced to issuer (the Zitadel
+// base URL), and exchanges it at tokenURL.
+func NewJWTProfileSource(issuer, tokenURL string, key *oidc.Key, timeout time.Duration) TokenSource {
+	issuer = strings.TrimRight(issuer, "/")
+	cli := &http.Client{Timeout: timeout}
+	return newCachedSource(func(ctx context.Context) (string, time.Time, error) {
+		assertion, err := key.SignAssertion(issuer, assertionTTL)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		form := url.Values{
+			"grant_type": {jwtBearerGrantType},
+			"scope":      {managementScope},
+			"assertion":  {assertion},
+		}
+		return requestToken(ctx, cli, tokenURL, form, nil)
+	})
+}
@@ -0,0 +1,315 @@
+package zitadel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+const listRolesPageSize = 1000
+
+const (
+	RoleSortKey         = "key"
+	RoleSortDisplayName = "display_name"
+)
+
+// RoleQuery filters and pages ListRoles. Key and DisplayName match
+// case-insensitive substrings; SortBy is RoleSortKey (default) or
+// RoleSortDisplayName. A zero Limit returns every match.
+type RoleQuery struct {
+	Key         string
+	DisplayName string
+	SortBy      string
+	Desc        bool
+	Offset      int
+	Limit       int
+}
+
+type RoleList struct {
+	Roles []Role `json:"roles"`
+	Total int    `json:"total"`
+}
+
+// ListRoles returns the project roles matching q. Filters, Offset and Limit
+// go to Zitadel's role search, which returns roles ordered by key, so a page
+// in key order costs one or, descending, two small requests. The search
+// cannot order by display name; that order fetches every match and sorts
+// here.
+func (h *httpClient) ListRoles(ctx context.Context, q RoleQuery) (*RoleList, error) {
+	var queries []map[string]interface{}
+	if q.Key != "" {
+		queries = append(queries, map[string]interface{}{
+			"key_query": map[string]string{"key": q.Key, "method": "TEXT_QUERY_METHOD_CONTAINS_IGNORE_CASE"},
+		})
+	}
+	if q.DisplayName != "" {
+		queries = append(queries, map[string]interface{}{
+			"display_name_query": map[string]string{"display_name": q.DisplayName, "method": "TEXT_QUERY_METHOD_CONTAINS_IGNORE_CASE"},
+		})
+	}
+	offset := q.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	count := q.Limit
+	if count <= 0 {
+		count = -1
+	}
+
+	switch q.SortBy {
+	case "", RoleSortKey:
+		if !q.Desc {
+			roles, total, err := h.searchRoles(ctx, queries, offset, count)
+			if err != nil {
+				return nil, err
+			}
+			return &RoleList{Roles: roles, Total: total}, nil
+		}
+		// read the mirrored window of the ascending order and reverse it
+		_, total, err := h.searchRoles(ctx, queries, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		hi := total - offset
+		if hi <= 0 {
+			return &RoleList{Roles: []Role{}, Total: total}, nil
+		}
+		lo := 0
+		if count > 0 && hi > count {
+			lo = hi - count
+		}
+		roles, total, err := h.searchRoles(ctx, queries, lo, hi-lo)
+		if err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(roles)-1; i < j; i, j = i+1, j-1 {
+			roles[i], roles[j] = roles[j], roles[i]
+		}
+		return &RoleList{Roles: roles, Total: total}, nil
+	case RoleSortDisplayName:
+		roles, _, err := h.searchRoles(ctx, queries, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(roles, func(i, j int) bool {
+			a, b := roles[i], roles[j]
+			if q.Desc {
+				a, b = b, a
+			}
+			if a.DisplayName != b.DisplayName {
+				return a.DisplayName < b.DisplayName
+			}
+			return a.Key < b.Key
+		})
+		list := &RoleList{Total: len(roles)}
+		if offset > len(roles) {
+			offset = len(roles)
+		}
+		hi := len(roles)
+		if count > 0 && offset+count < hi {
+			hi = offset + count
+		}
+		list.Roles = roles[offset:hi]
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unknown role sort column %q", q.SortBy)
+	}
+}
+
+// searchRoles returns count (-1 for all) project roles matching queries
+// from offset on, in Zitadel's key order, requesting at most
+// listRolesPageSize at a time, and the total number of matches.
+func (h *httpClient) searchRoles(ctx context.Context, queries []map[string]interface{}, offset, count int) ([]Role, int, error) {
+	roles := make([]Role, 0)
+	total := 0
+	for count < 0 || len(roles) < count {
+		limit := listRolesPageSize
+		if count >= 0 && count-len(roles) < limit {
+			limit = count - len(roles)
+		}
+		payload := map[string]interface{}{
+			"query": map[string]interface{}{
+				"offset": strconv.Itoa(offset + len(roles)),
+				"limit":  limit,
+				"asc":    true,
+			},
+		}
+		if len(queries) > 0 {
+			payload["queries"] = queries
+		}
+		b, _ := json.Marshal(payload)
+
+		endpoint := fmt.Sprintf("/management/v1/projects/%s/roles/_search", h.projectID(ctx))
+		req, _ := retryablehttp.NewRequest("POST", h.makeURL(endpoint), strings.NewReader(string(b)))
+		req = req.WithContext(ctx)
+
+		resp, err := h.doRequest(req)
+		if err != nil {
+			return nil, 0, err
+		}
+		if resp.StatusCode >= 300 {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, 0, &HTTPError{Op: "list roles", StatusCode: resp.StatusCode, Body: string(body)}
+		}
+
+		var out struct {
+			Details struct {
+				TotalResult string `json:"totalResult"`
+			} `json:"details"`
+			Result []struct {
+				Key         string `json:"key"`
+				DisplayName string `json:"displayName"`
+				Group       string `json:"group"`
+			} `json:"result"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if err != nil {
+			return nil, 0, fmt.Errorf("decode roles failed: %w", err)
+		}
+		total, _ = strconv.Atoi(out.Details.TotalResult)
+		for _, r := range out.Result {
+			roles = append(roles, Role{Key: r.Key, DisplayName: r.DisplayName, Group: r.Group})
+		}
+		if len(out.Result) < limit {
+			break
+		}
+	}
+	if total < offset+len(roles) {
+		total = offset + len(roles)
+	}
+	return roles, total, nil
+}
+
+// UpdateRole replaces the display name and group of an existing role.
+func (h *httpClient) UpdateRole(ctx context.Context, key, displayName, group string) error {
+	payload := map[string]interface{}{
+		"displayName": displayName,
+		"group":       group,
+	}
+	b, _ := json.Marshal(payload)
+
+	endpoint := fmt.Sprintf("/management/v1/projects/%s/roles/%s", h.projectID(ctx), key)
+	req, _ := retryablehttp.NewRequest("PUT", h.makeURL(endpoint), strings.NewReader(string(b)))
+	req = req.WithContext(ctx)
+
+	resp, err := h.doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return &HTTPError{Op: "update role", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return nil
+}
+
+const (
+	RoleCreated = "created"
+	RoleExisted = "existed"
+	RoleFailed  = "failed"
+)
+
+// RoleResult is the outcome of EnsureRoles for one role. Updated is set
+// when an existing role's display name was changed from Previous.
+type RoleResult struct {
+	Key      string `json:"key"`
+	Status   string `json:"status"`
+	Updated  bool   `json:"updated,omitempty"`
+	Previous string `json:"-"`
+	Error    string `json:"error,omitempty"`
+	Err      error  `json:"-"`
+}
+
+// EnsureRoles creates the roles that do not exist yet and reports the others
+// as existed, so it can be re-run safely. With updateDisplayName, existing
+// roles whose display name differs from Desc are updated (their group is
+// kept). Per-role failures are reported in the results; the error is only
+// set when the existing roles could not be listed.
+func (h *httpClient) EnsureRoles(ctx context.Context, roles []RoleInput, updateDisplayName bool) ([]RoleResult, error) {
+	list, err := h.ListRoles(ctx, RoleQuery{})
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]Role, len(list.Roles))
+	for _, r := range list.Roles {
+		existing[r.Key] = r
+	}
+
+	results := make([]RoleResult, 0, len(roles))
+	for _, in := range roles {
+		res := RoleResult{Key: in.Name}
+		cur, ok := existing[in.Name]
+		if !ok {
+			err := h.addRole(ctx, in)
+			switch {
+			case err == nil:
+				res.Status = RoleCreated
+				existing[in.Name] = Role{Key: in.Name, DisplayName: in.Desc, Group: in.Group}
+			case IsStatus(err, http.StatusConflict):
+				// created concurrently; its display name is unknown, so it is
+				// not updated
+				res.Status = RoleExisted
+			default:
+				res.Status = RoleFailed
+				res.Error = err.Error()
+				res.Err = err
+			}
+			results = append(results, res)
+			continue
+		}
+
+		res.Status = RoleExisted
+		if updateDisplayName && in.Desc != "" && in.Desc != cur.DisplayName {
+			if err := h.UpdateRole(ctx, in.Name, in.Desc, cur.Group); err != nil {
+				res.Status = RoleFailed
+				res.Error = err.Error()
+				res.Err = err
+			} else {
+				res.Updated = true
+				res.Previous = cur.DisplayName
+				cur.DisplayName = in.Desc
+				existing[in.Name] = cur
+			}
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (h *httpClient) addRole(ctx context.Context, in RoleInput) error {
+	group := in.Group
+	if group == "" {
+		group = "default"
+	}
+	payload := map[string]interface{}{
+		"roleKey":     in.Name,
+		"displayName": in.Desc,
+		"group":       group,
+	}
+	b, _ := json.Marshal(payload)
+
+	endpoint := fmt.Sprintf("/management/v1/projects/%s/roles", h.projectID(ctx))
+	req, _ := retryablehttp.NewRequest("POST", h.makeURL(endpoint), strings.NewReader(string(b)))
+	req = req.WithContext(ctx)
+
+	resp, err := h.doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return &HTTPError{Op: "create role", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return nil
+}
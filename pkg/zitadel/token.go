@@ -0,0 +1,212 @@
+package zitadel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/AbduAllahGabbar/service/pkg/config"
+	"github.com/AbduAllahGabbar/service/pkg/oidc"
+)
+
+const (
+	AuthStatic            = "static"
+	AuthClientCredentials = "client_credentials"
+	AuthJWTProfile        = "jwt_profile"
+
+	// managementScope asks for a token whose audience includes Zitadel's
+	// own APIs.
+	managementScope = "openid urn:zitadel:iam:org:project:id:zitadel:aud"
+	// tokenRefreshSkew renews cached tokens this long before they expire;
+	// tokens living shorter than twice the skew are renewed halfway.
+	tokenRefreshSkew = time.Minute
+)
+
+// TokenSource provides the bearer token for management API calls.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+	// Invalidate drops token if it is still the cached one and reports
+	// whether a different token can be obtained: it was dropped now or by a
+	// concurrent caller, or has already been replaced. That is whether a
+	// request rejected with 401 is worth retrying.
+	Invalidate(token string) bool
+}
+
+// NewTokenSource builds the token source selected by cfg.ZitadelAuth. When
+// that is empty the method is inferred: a key file selects the JWT profile,
+// a client ID client credentials, anything else the static token.
+func NewTokenSource(cfg *config.Config) (TokenSource, error) {
+	method := cfg.ZitadelAuth
+	if method == "" {
+		switch {
+		case cfg.ZitadelKeyFile != "":
+			method = AuthJWTProfile
+		case cfg.ZitadelClientID != "":
+			method = AuthClientCredentials
+		default:
+			method = AuthStatic
+		}
+	}
+	tokenURL := cfg.ZitadelTokenURL
+	if tokenURL == "" {
+		tokenURL = strings.TrimRight(cfg.ZitadelBaseURL, "/") + "/oauth/v2/token"
+	}
+
+	switch method {
+	case AuthStatic:
+		return StaticTokenSource(cfg.ZitadelToken), nil
+	case AuthClientCredentials:
+		if cfg.ZitadelClientID == "" || cfg.ZitadelClientSecret == "" {
+			return nil, errors.New("client credentials need ZITADEL_CLIENT_ID and ZITADEL_CLIENT_SECRET")
+		}
+		return NewClientCredentialsSource(tokenURL, cfg.ZitadelClientID, cfg.ZitadelClientSecret, cfg.RequestTimeout), nil
+	case AuthJWTProfile:
+		if cfg.ZitadelKeyFile == "" {
+			return nil, errors.New("jwt profile needs ZITADEL_KEY_FILE")
+		}
+		key, err := oidc.LoadKeyFile(cfg.ZitadelKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewJWTProfileSource(cfg.ZitadelBaseURL, tokenURL, key, cfg.RequestTimeout), nil
+	}
+	return nil, fmt.Errorf("unknown auth method %q (static, client_credentials or jwt_profile)", method)
+}
+
+type staticToken string
+
+// StaticTokenSource always returns token, e.g. a personal access token.
+func StaticTokenSource(token string) TokenSource { return staticToken(token) }
+
+func (t staticToken) Token(context.Context) (string, error) { return string(t), nil }
+
+func (t staticToken) Invalidate(string) bool { return false }
+
+// NewClientCredentialsSource obtains tokens with the OAuth2 client
+// credentials grant, authenticating to tokenURL with HTTP basic auth.
+func NewClientCredentialsSource(tokenURL, clientID, clientSecret string, timeout time.Duration) TokenSource {
+	cli := &http.Client{Timeout: timeout}
+	return newCachedSource(func(ctx context.Context) (string, time.Time, error) {
+		form := url.Values{
+			"grant_type": {"client_credentials"},
+			"scope":      {managementScope},
+		}
+		return requestToken(ctx, cli, tokenURL, form, func(req *http.Request) {
+			req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+		})
+	})
+}
+
+type tokenFetcher func(ctx context.Context) (token string, expiry time.Time, err error)
+
+// cachedSource caches the token fetched by fetch until shortly before it
+// expires. Concurrent callers needing a new token share one fetch.
+type cachedSource struct {
+	fetch tokenFetcher
+	group singleflight.Group
+
+	mu      sync.RWMutex
+	token   string
+	refresh time.Time
+	// dropped is the last invalidated token, so concurrent requests it
+	// failed also retry
+	dropped string
+}
+
+func newCachedSource(fetch tokenFetcher) *cachedSource {
+	return &cachedSource{fetch: fetch}
+}
+
+func (s *cachedSource) Token(ctx context.Context) (string, error) {
+	s.mu.RLock()
+	token, refresh := s.token, s.refresh
+	s.mu.RUnlock()
+	if token != "" && time.Now().Before(refresh) {
+		return token, nil
+	}
+
+	v, err, _ := s.group.Do("token", func() (interface{}, error) {
+		// the fetch is shared, so one caller giving up must not fail the
+		// others
+		now := time.Now()
+		token, expiry, err := s.fetch(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		refresh := expiry.Add(-tokenRefreshSkew)
+		if half := now.Add(expiry.Sub(now) / 2); refresh.Before(half) {
+			refresh = half
+		}
+		s.mu.Lock()
+		s.token, s.refresh = token, refresh
+		s.mu.Unlock()
+		return token, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+func (s *cachedSource) Invalidate(token string) bool {
+	if token == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch token {
+	case s.token:
+		s.token, s.dropped = "", token
+		return true
+	case s.dropped:
+		return true
+	}
+	return s.token != ""
+}
+
+// requestToken posts an OAuth2 token request and returns the access token
+// and its expiry.
+func requestToken(ctx context.Context, cli *http.Client, tokenURL string, form url.Values, prepare func(*http.Request)) (string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if prepare != nil {
+		prepare(req)
+	}
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return "", time.Time{}, &HTTPError{Op: "token request", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", time.Time{}, fmt.Errorf("decode token response: %w", err)
+	}
+	if out.AccessToken == "" {
+		return "", time.Time{}, errors.New("token response has no access_token")
+	}
+	if out.ExpiresIn <= 0 {
+		return "", time.Time{}, errors.New("token response has no expires_in")
+	}
+	return out.AccessToken, time.Now().Add(time.Duration(out.ExpiresIn) * time.Second), nil
+}